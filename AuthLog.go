@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCollector("base", &authLogCollector{}, 10*time.Second)
+	privilegedCollectors["ssh_auth"] = "the auth logs are only readable by root and the adm group"
+}
+
+// SSHAuthFailures counts failed SSH logins per source address over the
+// brute-force window. Crossing the threshold raises a brute_force event.
+type SSHAuthFailures struct {
+	WindowSeconds int64               `json:"window_seconds"`
+	Threshold     int                 `json:"threshold"`
+	Sources       []AuthFailureSource `json:"sources"`
+}
+
+type AuthFailureSource struct {
+	IP          string   `json:"ip"`
+	Attempts    int      `json:"attempts"`
+	Users       []string `json:"users"`
+	LastAttempt string   `json:"last_attempt"`
+}
+
+// BruteForce is the data of a brute_force event.
+type BruteForce struct {
+	IP            string   `json:"ip"`
+	Attempts      int      `json:"attempts"`
+	WindowSeconds int64    `json:"window_seconds"`
+	Users         []string `json:"users"`
+}
+
+// authAttempt is one failed login found in the logs.
+type authAttempt struct {
+	at   time.Time
+	user string
+}
+
+// authLogCollector tails the sshd messages of the syslog auth files and,
+// optionally, a journal export file, counting failed logins per address.
+type authLogCollector struct {
+	paths     []string
+	journal   string
+	threshold int
+	window    time.Duration
+
+	loaded   bool
+	cursors  map[string]*fileCursor
+	attempts map[string][]authAttempt // by source address, oldest first
+	alerted  map[string]bool          // addresses over the threshold
+}
+
+const authLogStateFile = "auth_log.json"
+
+func (c *authLogCollector) Name() string {
+	return "ssh_auth"
+}
+
+func (c *authLogCollector) configure(cfg *Config) error {
+	c.paths = cfg.AuthLog.Paths
+	c.journal = cfg.AuthLog.JournalExport
+	c.threshold = cfg.AuthLog.BruteForceThreshold
+	c.window = cfg.AuthLog.BruteForceWindow
+	return nil
+}
+
+func (c *authLogCollector) Collect(ctx context.Context) (interface{}, error) {
+	if !c.loaded {
+		c.cursors = make(map[string]*fileCursor)
+		if err := loadState(authLogStateFile, &c.cursors); err != nil {
+			log.Println("Ignoring unreadable auth log state:", err)
+		}
+		c.attempts = make(map[string][]authAttempt)
+		c.alerted = make(map[string]bool)
+		c.loaded = true
+	}
+
+	found := false
+	var errs []error
+	read := func(path string, complete func([]byte) int) []byte {
+		cur := c.cursors[path]
+		if cur == nil {
+			cur = &fileCursor{}
+			c.cursors[path] = cur
+		}
+		data, err := readNew(hostPath(path), cur, complete)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+			return nil
+		}
+		found = true
+		return data
+	}
+
+	now := time.Now()
+	for _, path := range c.paths {
+		data := read(path, wholeLines)
+		for _, line := range strings.Split(string(data), "\n") {
+			if at, msg, ok := parseSyslogLine(line, now); ok {
+				c.record(at, msg)
+			}
+		}
+	}
+	if c.journal != "" {
+		data := read(c.journal, func(b []byte) int {
+			_, n := parseJournalExport(b)
+			return n
+		})
+		entries, _ := parseJournalExport(data)
+		for _, e := range entries {
+			if e["SYSLOG_IDENTIFIER"] != "sshd" && e["_COMM"] != "sshd" && e["_COMM"] != "sshd-session" {
+				continue
+			}
+			usec, err := strconv.ParseInt(e["__REALTIME_TIMESTAMP"], 10, 64)
+			if err != nil {
+				continue
+			}
+			c.record(time.UnixMicro(usec), e["MESSAGE"])
+		}
+	}
+
+	saveCollectorState(authLogStateFile, c.cursors)
+	if err := errors.Join(errs...); err != nil {
+		return SSHAuthFailures{}, err
+	}
+	if !found {
+		return SSHAuthFailures{}, unavailable("no auth log found")
+	}
+	return c.evaluate(now), nil
+}
+
+// record counts msg if it is an sshd message about a failed login.
+func (c *authLogCollector) record(at time.Time, msg string) {
+	n, user, addr, ok := parseAuthFailure(msg)
+	if !ok {
+		return
+	}
+	for i := 0; i < n; i++ {
+		c.attempts[addr] = append(c.attempts[addr], authAttempt{at: at, user: user})
+	}
+}
+
+// evaluate drops attempts that have left the window, raises brute_force for
+// addresses that crossed the threshold and reports the remaining counts.
+func (c *authLogCollector) evaluate(now time.Time) SSHAuthFailures {
+	result := SSHAuthFailures{
+		WindowSeconds: int64(c.window / time.Second),
+		Threshold:     c.threshold,
+		Sources:       []AuthFailureSource{},
+	}
+	for addr, attempts := range c.attempts {
+		sort.SliceStable(attempts, func(i, j int) bool { return attempts[i].at.Before(attempts[j].at) })
+		i := 0
+		for i < len(attempts) && now.Sub(attempts[i].at) > c.window {
+			i++
+		}
+		attempts = attempts[i:]
+		if len(attempts) == 0 {
+			delete(c.attempts, addr)
+			delete(c.alerted, addr)
+			continue
+		}
+		c.attempts[addr] = attempts
+
+		users := distinctUsers(attempts)
+		last := attempts[len(attempts)-1].at
+		switch {
+		case len(attempts) >= c.threshold && !c.alerted[addr]:
+			c.alerted[addr] = true
+			events.emit(c.Name(), "brute_force", last, BruteForce{
+				IP:            addr,
+				Attempts:      len(attempts),
+				WindowSeconds: result.WindowSeconds,
+				Users:         users,
+			})
+		case len(attempts) < c.threshold:
+			delete(c.alerted, addr) // alert again if it crosses again
+		}
+		result.Sources = append(result.Sources, AuthFailureSource{
+			IP:          addr,
+			Attempts:    len(attempts),
+			Users:       users,
+			LastAttempt: last.Format(time.RFC3339),
+		})
+	}
+	sort.Slice(result.Sources, func(i, j int) bool {
+		if result.Sources[i].Attempts != result.Sources[j].Attempts {
+			return result.Sources[i].Attempts > result.Sources[j].Attempts
+		}
+		return result.Sources[i].IP < result.Sources[j].IP
+	})
+	return result
+}
+
+// maxReportedUsers bounds the user names listed per address; scanners try
+// long dictionaries.
+const maxReportedUsers = 10
+
+func distinctUsers(attempts []authAttempt) []string {
+	users := []string{}
+	seen := make(map[string]bool)
+	for _, a := range attempts {
+		if a.user != "" && !seen[a.user] && len(users) < maxReportedUsers {
+			seen[a.user] = true
+			users = append(users, a.user)
+		}
+	}
+	return users
+}
+
+// parseAuthFailure recognises the sshd messages of a failed login:
+//
+//	Failed password for root from 203.0.113.7 port 52144 ssh2
+//	Failed password for invalid user admin from 203.0.113.7 port 52144 ssh2
+//	Invalid user admin from 203.0.113.7 port 52144
+//	message repeated 3 times: [ Failed password for root from 203.0.113.7 port 52144 ssh2]
+//
+// A login attempt for an unknown user logs both an "Invalid user" line and,
+// with password authentication, a "Failed ... for invalid user" line; only
+// the first is counted. n is how many attempts the message stands for.
+func parseAuthFailure(msg string) (n int, user, addr string, ok bool) {
+	n = 1
+	if rest, found := strings.CutPrefix(msg, "message repeated "); found {
+		count, inner, _ := strings.Cut(rest, " times: [ ")
+		if n, _ = strconv.Atoi(count); n < 1 {
+			return 0, "", "", false
+		}
+		msg = strings.TrimSuffix(inner, "]")
+	}
+
+	var rest string
+	switch {
+	case strings.HasPrefix(msg, "Invalid user "):
+		rest = strings.TrimPrefix(msg, "Invalid user ")
+	case strings.HasPrefix(msg, "Failed password "), strings.HasPrefix(msg, "Failed keyboard-interactive"):
+		_, rest, _ = strings.Cut(msg, " for ")
+		if rest == "" || strings.HasPrefix(rest, "invalid user ") {
+			return 0, "", "", false
+		}
+	default:
+		return 0, "", "", false
+	}
+
+	// The user name is client-chosen and may contain spaces, so the
+	// address is taken from the last " from ".
+	i := strings.LastIndex(rest, " from ")
+	if i < 0 {
+		return 0, "", "", false
+	}
+	user = rest[:i]
+	fields := strings.Fields(rest[i+len(" from "):])
+	if len(fields) == 0 {
+		return 0, "", "", false
+	}
+	ip, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return 0, "", "", false
+	}
+	return n, user, ip.Unmap().String(), true
+}
+
+// parseSyslogLine splits an sshd line of a syslog file into its time and
+// message. Both the traditional "Oct 18 05:20:01 host sshd[812]: ..." and
+// the RFC 3339 timestamps of newer rsyslog defaults are understood.
+func parseSyslogLine(line string, now time.Time) (time.Time, string, bool) {
+	var at time.Time
+	var rest string
+	if first, after, ok := strings.Cut(line, " "); ok && len(first) > 0 && first[0] >= '0' && first[0] <= '9' {
+		t, err := time.Parse(time.RFC3339Nano, first)
+		if err != nil {
+			return time.Time{}, "", false
+		}
+		at, rest = t, after
+	} else {
+		if len(line) < len(time.Stamp)+1 {
+			return time.Time{}, "", false
+		}
+		t, err := time.ParseInLocation(time.Stamp, line[:len(time.Stamp)], time.Local)
+		if err != nil {
+			return time.Time{}, "", false
+		}
+		// The year is not logged; a date ahead of now is from last year.
+		at = t.AddDate(now.Year(), 0, 0)
+		if at.After(now.Add(24 * time.Hour)) {
+			at = at.AddDate(-1, 0, 0)
+		}
+		rest = line[len(time.Stamp)+1:]
+	}
+
+	// rest is "host tag[pid]: message"
+	_, rest, _ = strings.Cut(rest, " ")
+	tag, msg, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return time.Time{}, "", false
+	}
+	if i := strings.IndexByte(tag, '['); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag != "sshd" && tag != "sshd-session" {
+		return time.Time{}, "", false
+	}
+	return at, msg, true
+}
+
+// wholeLines is the readNew complete func for text logs.
+func wholeLines(b []byte) int {
+	return bytes.LastIndexByte(b, '\n') + 1
+}
+
+// parseJournalExport parses entries in the journal export format written by
+// journalctl -o export: FIELD=value lines, binary fields as the name, a
+// newline, a little-endian uint64 length and the data, and a blank line
+// after each entry. It returns the complete entries and how many bytes they
+// take up.
+func parseJournalExport(data []byte) ([]map[string]string, int) {
+	var entries []map[string]string
+	consumed := 0
+	entry := make(map[string]string)
+	pos := 0
+	for pos < len(data) {
+		nl := bytes.IndexByte(data[pos:], '\n')
+		if nl < 0 {
+			break
+		}
+		line := data[pos : pos+nl]
+		if len(line) == 0 {
+			pos += nl + 1
+			if len(entry) > 0 {
+				entries = append(entries, entry)
+				entry = make(map[string]string)
+			}
+			consumed = pos
+			continue
+		}
+		if name, value, ok := bytes.Cut(line, []byte("=")); ok {
+			entry[string(name)] = string(value)
+			pos += nl + 1
+			continue
+		}
+		start := pos + nl + 1
+		if start+8 > len(data) {
+			break
+		}
+		size := binary.LittleEndian.Uint64(data[start:])
+		end := uint64(start+8) + size
+		if end+1 > uint64(len(data)) {
+			break
+		}
+		entry[string(line)] = string(data[start+8 : end])
+		pos = int(end) + 1
+	}
+	return entries, consumed
+}
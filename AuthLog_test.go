@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAuthFailure(t *testing.T) {
+	tests := []struct {
+		msg  string
+		n    int
+		user string
+		addr string
+		ok   bool
+	}{
+		{"Failed password for root from 203.0.113.7 port 52144 ssh2", 1, "root", "203.0.113.7", true},
+		{"Invalid user admin from 203.0.113.7 port 52144", 1, "admin", "203.0.113.7", true},
+		{"Failed password for invalid user admin from 203.0.113.7 port 52144 ssh2", 0, "", "", false},
+		{"message repeated 3 times: [ Failed password for root from 203.0.113.7 port 52144 ssh2]", 3, "root", "203.0.113.7", true},
+		{"Failed password for alice from 2001:db8::1b3 port 57626 ssh2", 1, "alice", "2001:db8::1b3", true},
+		{"Failed password for alice from ::ffff:10.0.2.20 port 57626 ssh2", 1, "alice", "10.0.2.20", true},
+		{"Failed keyboard-interactive/pam for bob from 198.51.100.23 port 51514 ssh2", 1, "bob", "198.51.100.23", true},
+		// a client-chosen user name containing " from "
+		{"Invalid user x from 1.2.3.4 from 198.51.100.23 port 51514", 1, "x from 1.2.3.4", "198.51.100.23", true},
+		{"Invalid user  from 198.51.100.23 port 51514", 1, "", "198.51.100.23", true},
+		{"Accepted password for alice from 2001:db8::1b3 port 57626 ssh2", 0, "", "", false},
+		{"message repeated zero times: [ Failed password for root from 203.0.113.7 port 1 ssh2]", 0, "", "", false},
+		{"Failed password for root from somewhere port 1 ssh2", 0, "", "", false},
+		{"", 0, "", "", false},
+	}
+	for _, tt := range tests {
+		n, user, addr, ok := parseAuthFailure(tt.msg)
+		if n != tt.n || user != tt.user || addr != tt.addr || ok != tt.ok {
+			t.Errorf("parseAuthFailure(%q) = %d, %q, %q, %v, want %d, %q, %q, %v",
+				tt.msg, n, user, addr, ok, tt.n, tt.user, tt.addr, tt.ok)
+		}
+	}
+}
+
+func TestParseAuthLog(t *testing.T) {
+	useFixtures(t, "laptop")
+	data, err := os.ReadFile(hostPath("/var/log/auth.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, time.October, 18, 5, 0, 0, 0, time.UTC)
+	// Times are kept in the zone they were logged in, local time for the
+	// traditional timestamps, so the golden file does not depend on TZ.
+	type failure struct {
+		Time  string `json:"time"`
+		Count int    `json:"count"`
+		User  string `json:"user"`
+		Addr  string `json:"addr"`
+	}
+	failures := []failure{}
+	for _, line := range strings.Split(string(data), "\n") {
+		at, msg, ok := parseSyslogLine(line, now)
+		if !ok {
+			continue
+		}
+		if n, user, addr, ok := parseAuthFailure(msg); ok {
+			failures = append(failures, failure{at.Format(time.StampMicro), n, user, addr})
+		}
+	}
+	checkGolden(t, "auth_log", failures)
+}
+
+func TestParseSyslogLine(t *testing.T) {
+	now := time.Date(2026, time.January, 2, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		line string
+		want time.Time
+		msg  string
+		ok   bool
+	}{
+		{
+			line: "2026-01-01T23:59:58.5+00:00 web01 sshd[3001]: Invalid user admin from 203.0.113.7 port 40112",
+			want: time.Date(2026, time.January, 1, 23, 59, 58, 5e8, time.UTC),
+			msg:  "Invalid user admin from 203.0.113.7 port 40112",
+			ok:   true,
+		},
+		{
+			line: "Jan  1 23:59:58 web01 sshd-session[3001]: Connection closed",
+			want: time.Date(2026, time.January, 1, 23, 59, 58, 0, time.Local),
+			msg:  "Connection closed",
+			ok:   true,
+		},
+		{
+			// ahead of now: from last year
+			line: "Dec 31 10:00:00 web01 sshd[3001]: Connection closed",
+			want: time.Date(2025, time.December, 31, 10, 0, 0, 0, time.Local),
+			msg:  "Connection closed",
+			ok:   true,
+		},
+		{line: "Jan  1 23:59:58 web01 CRON[2201]: session opened"},
+		{line: "2026-13-01T00:00:00Z web01 sshd[1]: x"},
+		{line: "short"},
+	}
+	for _, tt := range tests {
+		at, msg, ok := parseSyslogLine(tt.line, now)
+		if ok != tt.ok || msg != tt.msg || !at.Equal(tt.want) {
+			t.Errorf("parseSyslogLine(%q) = %s, %q, %v, want %s, %q, %v", tt.line, at, msg, ok, tt.want, tt.msg, tt.ok)
+		}
+	}
+}
+
+func TestParseJournalExport(t *testing.T) {
+	binary := "MESSAGE\n\x05\x00\x00\x00\x00\x00\x00\x00a\nb c\n"
+	data := "__REALTIME_TIMESTAMP=1760762472551002\n_COMM=sshd\nMESSAGE=Invalid user admin from 203.0.113.7 port 40112\n\n" +
+		"__REALTIME_TIMESTAMP=1760762474102233\n_COMM=sshd\n" + binary + "\n" +
+		"__REALTIME_TIMESTAMP=1760762480000000\nMESSAGE=incomplete"
+	entries, n := parseJournalExport([]byte(data))
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2: %q", len(entries), entries)
+	}
+	if entries[0]["MESSAGE"] != "Invalid user admin from 203.0.113.7 port 40112" || entries[0]["_COMM"] != "sshd" {
+		t.Errorf("first entry = %q", entries[0])
+	}
+	if entries[1]["MESSAGE"] != "a\nb c" {
+		t.Errorf("binary field = %q, want %q", entries[1]["MESSAGE"], "a\nb c")
+	}
+	if want := strings.Index(data, "__REALTIME_TIMESTAMP=1760762480000000"); n != want {
+		t.Errorf("consumed %d bytes, want %d", n, want)
+	}
+}
+
+func TestAuthLogEvaluate(t *testing.T) {
+	events.drain()
+	c := &authLogCollector{
+		threshold: 3,
+		window:    time.Minute,
+		attempts:  make(map[string][]authAttempt),
+		alerted:   make(map[string]bool),
+	}
+	start := time.Date(2026, time.October, 18, 5, 0, 0, 0, time.UTC)
+	fail := func(seconds int) {
+		c.record(start.Add(time.Duration(seconds)*time.Second), "Failed password for root from 203.0.113.7 port 52144 ssh2")
+	}
+	check := func(step string, seconds, attempts, alerts int) {
+		t.Helper()
+		result := c.evaluate(start.Add(time.Duration(seconds) * time.Second))
+		got := 0
+		if len(result.Sources) > 0 {
+			got = result.Sources[0].Attempts
+		}
+		if got != attempts {
+			t.Errorf("%s: %d attempts in the window, want %d", step, got, attempts)
+		}
+		if n := len(events.drain()); n != alerts {
+			t.Errorf("%s: %d brute_force events, want %d", step, n, alerts)
+		}
+	}
+
+	fail(0)
+	fail(10)
+	check("below the threshold", 10, 2, 0)
+	fail(20)
+	check("crossing the threshold", 20, 3, 1)
+	fail(30)
+	check("still over it", 30, 4, 0)
+	check("first attempts expired", 75, 2, 0)
+	fail(80)
+	check("crossing again", 80, 3, 1)
+	check("window expired", 200, 0, 0)
+	if len(c.attempts) != 0 || len(c.alerted) != 0 {
+		t.Errorf("state left after the window: %v, %v", c.attempts, c.alerted)
+	}
+}
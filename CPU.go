@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCollector("base", &cpuCollector{}, 5*time.Second)
+}
+
+// CPUUsage is how busy the CPUs were since the previous run, plus the
+// kernel's load averages and current run queue.
+type CPUUsage struct {
+	Total                    CPUTimes    `json:"total"`
+	Cores                    []CoreUsage `json:"cores"`
+	Load1                    float64     `json:"load_1"`
+	Load5                    float64     `json:"load_5"`
+	Load15                   float64     `json:"load_15"`
+	ContextSwitchesPerSecond float64     `json:"context_switches_per_second"`
+	ProcsRunning             int         `json:"procs_running"`
+	ProcsBlocked             int         `json:"procs_blocked"`
+}
+
+// CPUTimes splits the time of one CPU, or all of them, between the states
+// /proc/stat counts. UsedPercent is everything but idle and iowait.
+type CPUTimes struct {
+	UsedPercent    float64 `json:"used_percent"`
+	UserPercent    float64 `json:"user_percent"`
+	NicePercent    float64 `json:"nice_percent"`
+	SystemPercent  float64 `json:"system_percent"`
+	IdlePercent    float64 `json:"idle_percent"`
+	IOWaitPercent  float64 `json:"iowait_percent"`
+	IRQPercent     float64 `json:"irq_percent"`
+	SoftIRQPercent float64 `json:"softirq_percent"`
+	StealPercent   float64 `json:"steal_percent"`
+}
+
+type CoreUsage struct {
+	CPU int `json:"cpu"`
+	CPUTimes
+}
+
+// cpuTicks is one cpu line of /proc/stat, in USER_HZ. Guest time is already
+// part of user and nice, so it is not kept.
+type cpuTicks struct {
+	User, Nice, System, Idle, IOWait, IRQ, SoftIRQ, Steal uint64
+}
+
+func (t cpuTicks) total() uint64 {
+	return t.User + t.Nice + t.System + t.Idle + t.IOWait + t.IRQ + t.SoftIRQ + t.Steal
+}
+
+// cpuSample is /proc/stat at one moment.
+type cpuSample struct {
+	at      time.Time
+	total   cpuTicks
+	cores   map[int]cpuTicks
+	ctxt    uint64
+	running int
+	blocked int
+}
+
+// firstSampleGap is how long collectors reporting rates wait between the
+// two samples of their first run, when there is no previous run to compare
+// with.
+const firstSampleGap = time.Second
+
+// nextSample reads a new sample and returns it with the one in *last, which
+// it then replaces. On the first run, with no sample in *last, it takes two
+// firstSampleGap apart; the first is kept even if ctx ends the wait.
+func nextSample[T any](ctx context.Context, last **T, read func() (*T, error)) (prev, cur *T, err error) {
+	if *last == nil {
+		first, err := read()
+		if err != nil {
+			return nil, nil, err
+		}
+		*last = first
+		select {
+		case <-time.After(firstSampleGap):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	cur, err = read()
+	if err != nil {
+		return nil, nil, err
+	}
+	prev, *last = *last, cur
+	return prev, cur, nil
+}
+
+// cpuCollector reports CPU utilization from the difference between two
+// samples of /proc/stat: the previous run's and this one's.
+type cpuCollector struct {
+	prev *cpuSample
+}
+
+func (c *cpuCollector) Name() string {
+	return "cpu"
+}
+
+func (c *cpuCollector) Collect(ctx context.Context) (interface{}, error) {
+	prev, cur, err := nextSample(ctx, &c.prev, readCPUSample)
+	if err != nil {
+		return CPUUsage{}, err
+	}
+
+	usage := CPUUsage{
+		Total:        cpuPercentages(prev.total, cur.total),
+		Cores:        []CoreUsage{},
+		ProcsRunning: cur.running,
+		ProcsBlocked: cur.blocked,
+	}
+	for cpu, after := range cur.cores {
+		// CPUs brought online since the previous sample wait for the next
+		if before, ok := prev.cores[cpu]; ok {
+			usage.Cores = append(usage.Cores, CoreUsage{CPU: cpu, CPUTimes: cpuPercentages(before, after)})
+		}
+	}
+	sort.Slice(usage.Cores, func(i, j int) bool { return usage.Cores[i].CPU < usage.Cores[j].CPU })
+	if elapsed := cur.at.Sub(prev.at).Seconds(); elapsed > 0 && cur.ctxt >= prev.ctxt {
+		usage.ContextSwitchesPerSecond = round2(float64(cur.ctxt-prev.ctxt) / elapsed)
+	}
+
+	usage.Load1, usage.Load5, usage.Load15, err = readLoadAverage()
+	return usage, err
+}
+
+// cpuPercentages turns the ticks spent in each state between two samples
+// into percentages. A CPU that did not tick at all is reported idle.
+func cpuPercentages(before, after cpuTicks) CPUTimes {
+	if after.total() <= before.total() {
+		return CPUTimes{IdlePercent: 100}
+	}
+	total := float64(after.total() - before.total())
+	pct := func(a, b uint64) float64 {
+		if a < b {
+			return 0 // counters going backwards, seen with some hypervisors
+		}
+		return round2(float64(a-b) / total * 100)
+	}
+	t := CPUTimes{
+		UserPercent:    pct(after.User, before.User),
+		NicePercent:    pct(after.Nice, before.Nice),
+		SystemPercent:  pct(after.System, before.System),
+		IdlePercent:    pct(after.Idle, before.Idle),
+		IOWaitPercent:  pct(after.IOWait, before.IOWait),
+		IRQPercent:     pct(after.IRQ, before.IRQ),
+		SoftIRQPercent: pct(after.SoftIRQ, before.SoftIRQ),
+		StealPercent:   pct(after.Steal, before.Steal),
+	}
+	t.UsedPercent = round2(math.Max(0, 100-t.IdlePercent-t.IOWaitPercent))
+	return t
+}
+
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// readCPUSample parses /proc/stat:
+//
+//	cpu  4705 356 584 3699176 23060 0 277 0 0 0
+//	cpu0 1393 280 263 1845795 11620 0 226 0 0 0
+//	ctxt 1990473
+//	procs_running 2
+//	procs_blocked 0
+func readCPUSample() (*cpuSample, error) {
+	f, err := os.Open(hostPath("/proc/stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sample := &cpuSample{at: time.Now(), cores: make(map[int]cpuTicks)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch key := fields[0]; {
+		case key == "cpu":
+			if sample.total, err = parseCPUTicks(fields[1:]); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+		case strings.HasPrefix(key, "cpu"):
+			cpu, err := strconv.Atoi(key[3:])
+			if err != nil {
+				continue
+			}
+			ticks, err := parseCPUTicks(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.Name(), err)
+			}
+			sample.cores[cpu] = ticks
+		case key == "ctxt":
+			sample.ctxt, _ = strconv.ParseUint(fields[1], 10, 64)
+		case key == "procs_running":
+			sample.running, _ = strconv.Atoi(fields[1])
+		case key == "procs_blocked":
+			sample.blocked, _ = strconv.Atoi(fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if sample.total.total() == 0 {
+		return nil, fmt.Errorf("%s has no cpu line", f.Name())
+	}
+	return sample, nil
+}
+
+// parseCPUTicks parses the counters of a cpu line. Kernels older than 2.6.11
+// have no steal column; missing columns count as zero.
+func parseCPUTicks(fields []string) (cpuTicks, error) {
+	var values [8]uint64
+	for i := 0; i < len(values) && i < len(fields); i++ {
+		v, err := strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return cpuTicks{}, fmt.Errorf("malformed cpu counter %q", fields[i])
+		}
+		values[i] = v
+	}
+	return cpuTicks{
+		User:    values[0],
+		Nice:    values[1],
+		System:  values[2],
+		Idle:    values[3],
+		IOWait:  values[4],
+		IRQ:     values[5],
+		SoftIRQ: values[6],
+		Steal:   values[7],
+	}, nil
+}
+
+// readLoadAverage returns the 1, 5 and 15 minute load averages.
+func readLoadAverage() (load1, load5, load15 float64, err error) {
+	data, err := readHostFile("/proc/loadavg")
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	fields := strings.Fields(data)
+	if len(fields) < 3 {
+		return 0, 0, 0, fmt.Errorf("unexpected /proc/loadavg %q", data)
+	}
+	var loads [3]float64
+	for i := range loads {
+		if loads[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("unexpected /proc/loadavg %q", data)
+		}
+	}
+	return loads[0], loads[1], loads[2], nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReadCPUSample(t *testing.T) {
+	useFixtures(t, "laptop")
+	sample, err := readCPUSample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "cpu_sample", map[string]interface{}{
+		"total":   sample.total,
+		"cores":   sample.cores,
+		"ctxt":    sample.ctxt,
+		"running": sample.running,
+		"blocked": sample.blocked,
+	})
+}
+
+func TestReadLoadAverage(t *testing.T) {
+	useFixtures(t, "laptop")
+	load1, load5, load15, err := readLoadAverage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "loadavg", []float64{load1, load5, load15})
+}
+
+func TestParseCPUTicks(t *testing.T) {
+	tests := []struct {
+		fields  []string
+		want    cpuTicks
+		wantErr bool
+	}{
+		{fields: []string{"4705", "356", "584", "3699176", "23060", "0", "277", "12", "0", "0"},
+			want: cpuTicks{User: 4705, Nice: 356, System: 584, Idle: 3699176, IOWait: 23060, SoftIRQ: 277, Steal: 12}},
+		// before 2.6.11: no steal column
+		{fields: []string{"1", "2", "3", "4", "5", "6", "7"},
+			want: cpuTicks{User: 1, Nice: 2, System: 3, Idle: 4, IOWait: 5, IRQ: 6, SoftIRQ: 7}},
+		{fields: []string{"1", "x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCPUTicks(tt.fields)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseCPUTicks(%q) = %+v, %v, want %+v", tt.fields, got, err, tt.want)
+		}
+	}
+}
+
+func TestCPUPercentages(t *testing.T) {
+	before := cpuTicks{User: 100, System: 50, Idle: 800, IOWait: 50}
+	tests := []struct {
+		name  string
+		after cpuTicks
+		want  CPUTimes
+	}{
+		{"busy", cpuTicks{User: 150, System: 75, Idle: 875, IOWait: 100},
+			CPUTimes{UsedPercent: 37.5, UserPercent: 25, SystemPercent: 12.5, IdlePercent: 37.5, IOWaitPercent: 25}},
+		{"no ticks", before, CPUTimes{IdlePercent: 100}},
+		// a counter going backwards counts as zero
+		{"system backwards", cpuTicks{User: 200, System: 40, Idle: 900, IOWait: 50},
+			CPUTimes{UsedPercent: 47.37, UserPercent: 52.63, IdlePercent: 52.63}},
+	}
+	for _, tt := range tests {
+		if got := cpuPercentages(before, tt.after); got != tt.want {
+			t.Errorf("%s: cpuPercentages = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
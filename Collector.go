@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Collector gathers one piece of system information. Name is also the key the
+// result is reported under in SystemInfo.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context) (interface{}, error)
+}
+
+// typedCollector adapts a plain function returning a concrete type to the
+// Collector interface.
+type typedCollector[T any] struct {
+	name string
+	fn   func(ctx context.Context) (T, error)
+}
+
+func (c typedCollector[T]) Name() string {
+	return c.name
+}
+
+func (c typedCollector[T]) Collect(ctx context.Context) (interface{}, error) {
+	return c.fn(ctx)
+}
+
+// configurable is implemented by collectors with settings of their own in
+// the config. configure is called before the collector first runs.
+type configurable interface {
+	configure(cfg *Config) error
+}
+
+// privilegedCollectors maps collectors that need more than an unprivileged
+// user may do to what that is. Run without it they report
+// permission_denied; the agent says so at startup.
+var privilegedCollectors = map[string]string{}
+
+// runOnce as a collector interval means the collector only runs at startup,
+// for things that cannot change while the agent is running. A run that fails
+// is retried until one succeeds.
+const runOnce time.Duration = 0
+
+// registration is a registered collector and how often it runs by default.
+// timeout is filled in from the config when the collector is enabled.
+type registration struct {
+	Collector
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// collectorSets holds every registered collector, grouped by set. The "base"
+// set runs everywhere; other sets (e.g. "debian") are enabled on demand.
+var collectorSets = map[string][]registration{}
+
+func registerCollector(set string, c Collector, interval time.Duration) {
+	collectorSets[set] = append(collectorSets[set], registration{Collector: c, interval: interval})
+}
+
+// register wraps fn as a collector named name, run every interval, and adds
+// it to set.
+func register[T any](set, name string, interval time.Duration, fn func(ctx context.Context) (T, error)) {
+	registerCollector(set, typedCollector[T]{name: name, fn: fn}, interval)
+}
+
+// enabledCollectors returns the collectors of the given sets, in set order and
+// then registration order.
+func enabledCollectors(sets []string) ([]registration, error) {
+	var collectors []registration
+	seen := make(map[string]bool)
+	for _, set := range sets {
+		registered, ok := collectorSets[set]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector set %q", set)
+		}
+		for _, c := range registered {
+			if seen[c.Name()] {
+				return nil, fmt.Errorf("collector %q is registered more than once", c.Name())
+			}
+			seen[c.Name()] = true
+			collectors = append(collectors, c)
+		}
+	}
+	return collectors, nil
+}
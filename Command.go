@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// commandRunner runs the external commands collectors depend on. Collectors
+// go through runner rather than os/exec so their parsing can be exercised
+// against recorded output.
+type commandRunner interface {
+	// Output runs name with args and returns its stdout. A command that
+	// ran and failed returns an *exitError.
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+var runner commandRunner = execRunner{}
+
+// exitError is a command that ran and exited unsuccessfully.
+type exitError struct {
+	msg    string // e.g. "exit status 1"
+	stderr []byte
+}
+
+func (e *exitError) Error() string {
+	return e.msg
+}
+
+// execRunner runs commands on this host.
+type execRunner struct{}
+
+func (execRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		return out, &exitError{msg: ee.Error(), stderr: ee.Stderr}
+	}
+	return out, err
+}
+
+// replayRunner answers commands from output recorded under dir, one fixture
+// per command line. For "firewall-cmd --state" it reads:
+//
+//	firewall-cmd_--state.out   stdout
+//	firewall-cmd_--state.err   stderr, optional
+//	firewall-cmd_--state.exit  exit status, optional, 0 if missing
+//
+// A command with none of these files behaves as if it were not installed.
+type replayRunner struct {
+	dir string
+}
+
+func (r replayRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	base := filepath.Join(r.dir, fixtureName(name, args))
+	stdout, errOut := os.ReadFile(base + ".out")
+	stderr, errErr := os.ReadFile(base + ".err")
+	exit, errExit := os.ReadFile(base + ".exit")
+	for _, err := range []error{errOut, errErr, errExit} {
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if errOut != nil && errErr != nil && errExit != nil {
+		return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+	}
+
+	if errExit == nil {
+		code, err := strconv.Atoi(strings.TrimSpace(string(exit)))
+		if err != nil {
+			return nil, fmt.Errorf("%s.exit: %w", base, err)
+		}
+		if code != 0 {
+			return stdout, &exitError{msg: fmt.Sprintf("exit status %d", code), stderr: stderr}
+		}
+	}
+	return stdout, nil
+}
+
+// fixtureName is the file name a command line is recorded under: its words
+// joined by underscores, with path separators replaced.
+func fixtureName(name string, args []string) string {
+	return strings.ReplaceAll(strings.Join(append([]string{name}, args...), "_"), "/", "_")
+}
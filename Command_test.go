@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplayRunner(t *testing.T) {
+	tests := []struct {
+		scenario string
+		argv     []string
+		stdout   string
+		exit     string // exitError message, if the command fails
+		notFound bool
+	}{
+		{scenario: "server", argv: []string{"firewall-cmd", "--state"}, stdout: "running\n"},
+		{scenario: "minimal", argv: []string{"iwconfig"}, stdout: ""},
+		{scenario: "minimal", argv: []string{"nft", "-j", "-s", "list", "ruleset"}, exit: "exit status 1"},
+		{scenario: "laptop", argv: []string{"firewall-cmd", "--state"}, notFound: true},
+	}
+	for _, tt := range tests {
+		r := replayRunner{dir: filepath.Join("testdata", "commands", tt.scenario)}
+		out, err := r.Output(context.Background(), tt.argv[0], tt.argv[1:]...)
+		var exitErr *exitError
+		switch {
+		case tt.notFound:
+			if !errors.Is(err, exec.ErrNotFound) {
+				t.Errorf("%s %v: err = %v, want not found", tt.scenario, tt.argv, err)
+			}
+		case tt.exit != "":
+			if !errors.As(err, &exitErr) || exitErr.msg != tt.exit || len(exitErr.stderr) == 0 {
+				t.Errorf("%s %v: err = %v, want %s with stderr", tt.scenario, tt.argv, err, tt.exit)
+			}
+		case err != nil:
+			t.Errorf("%s %v: %v", tt.scenario, tt.argv, err)
+		case string(out) != tt.stdout:
+			t.Errorf("%s %v: stdout = %q, want %q", tt.scenario, tt.argv, out, tt.stdout)
+		}
+	}
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigPath = "/etc/shm-agent/config.yaml"
+
+// Config is the agent configuration. Values come from, in increasing order of
+// precedence: built-in defaults, the YAML config file, SHM_* environment
+// variables and command-line flags.
+type Config struct {
+	Server        ServerConfig               `yaml:"server"`
+	Agent         AgentConfig                `yaml:"agent"`
+	Interval      time.Duration              `yaml:"interval"`
+	Workers       int                        `yaml:"workers"`
+	Display       bool                       `yaml:"display"`
+	CollectorSets []string                   `yaml:"collector_sets"`
+	Collectors    map[string]CollectorConfig `yaml:"collectors"`
+	Timeouts      TimeoutConfig              `yaml:"timeouts"`
+	StateDir      string                     `yaml:"state_dir"`
+	HostRoot      string                     `yaml:"host_root"`
+	Disk          DiskConfig                 `yaml:"disk"`
+	DiskIO        DiskIOConfig               `yaml:"disk_io"`
+	AuthLog       AuthLogConfig              `yaml:"auth_log"`
+	Scanner       ScannerConfig              `yaml:"scanner"`
+	Firewall      FirewallConfig             `yaml:"firewall"`
+	Helper        HelperConfig               `yaml:"helper"`
+	Spool         SpoolConfig                `yaml:"spool"`
+}
+
+type ServerConfig struct {
+	URL string `yaml:"url"`
+}
+
+type AgentConfig struct {
+	// Name is the top-level key snapshots are wrapped in. The server uses it
+	// as the table name. When empty, an identity is derived from the
+	// hostname and machine-id on first start and saved in the state dir.
+	Name string `yaml:"name"`
+}
+
+// CollectorConfig overrides the defaults of a single collector. An interval
+// of 0s runs the collector only once, at startup, retrying until it
+// succeeds; a zero timeout uses timeouts.collect.
+type CollectorConfig struct {
+	Enabled  *bool          `yaml:"enabled"`
+	Interval *time.Duration `yaml:"interval"`
+	Timeout  time.Duration  `yaml:"timeout"`
+}
+
+type TimeoutConfig struct {
+	Dial    time.Duration `yaml:"dial"`
+	Write   time.Duration `yaml:"write"`
+	Pong    time.Duration `yaml:"pong"`
+	Collect time.Duration `yaml:"collect"`
+}
+
+// DiskConfig selects the filesystems the disk collector reports, by type and
+// by mount point. Path patterns are shell globs that also cover everything
+// mounted below a matching directory.
+type DiskConfig struct {
+	IncludeFSTypes []string `yaml:"include_fstypes"`
+	ExcludeFSTypes []string `yaml:"exclude_fstypes"`
+	IncludePaths   []string `yaml:"include_paths"`
+	ExcludePaths   []string `yaml:"exclude_paths"`
+}
+
+// DiskIOConfig configures the disk_io collector. ExcludeDevices are shell
+// globs matched against device names; partitions are only reported when
+// Partitions is set.
+type DiskIOConfig struct {
+	ExcludeDevices []string `yaml:"exclude_devices"`
+	Partitions     bool     `yaml:"partitions"`
+}
+
+// AuthLogConfig configures the ssh_auth collector. Paths are syslog files
+// holding sshd messages; those that do not exist are skipped. JournalExport
+// is an optional file in journalctl's export format, for hosts that only
+// log to the journal.
+type AuthLogConfig struct {
+	Paths               []string      `yaml:"paths"`
+	JournalExport       string        `yaml:"journal_export"`
+	BruteForceThreshold int           `yaml:"brute_force_threshold"`
+	BruteForceWindow    time.Duration `yaml:"brute_force_window"`
+}
+
+// ScannerConfig configures the optional network scanner, which runs nmap
+// against Targets (addresses, subnets in CIDR notation or host names) every
+// Interval and sends the results as scan_result messages. Arguments are
+// passed to nmap before the target; Ports, if set, is its -p argument.
+type ScannerConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Targets     []string      `yaml:"targets"`
+	Interval    time.Duration `yaml:"interval"`
+	Concurrency int           `yaml:"concurrency"`
+	Timeout     time.Duration `yaml:"timeout"`
+	Ports       string        `yaml:"ports"`
+	Arguments   []string      `yaml:"arguments"`
+}
+
+// FirewallConfig configures the firewall collector. Baseline is the approved
+// ruleset written by bless-firewall; while it exists the collector raises
+// firewall_drift when the rules differ from it. Empty disables the check.
+type FirewallConfig struct {
+	Baseline string `yaml:"baseline"`
+}
+
+// HelperConfig configures the privileged helper. When Socket is set the
+// agent has the helper run the commands that need root; the helper itself
+// listens there and answers only the users in AllowUsers.
+type HelperConfig struct {
+	Socket     string   `yaml:"socket"`
+	AllowUsers []string `yaml:"allow_users"`
+}
+
+type SpoolConfig struct {
+	MaxSegmentBytes int64         `yaml:"max_segment_bytes"`
+	MaxBytes        int64         `yaml:"max_bytes"`
+	MaxAge          time.Duration `yaml:"max_age"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Server:        ServerConfig{URL: "ws://localhost:8080/WebSockCon/serverws"},
+		Interval:      5 * time.Second,
+		Workers:       4,
+		Display:       true,
+		CollectorSets: []string{"base"},
+		Timeouts: TimeoutConfig{
+			Dial:    45 * time.Second,
+			Write:   10 * time.Second,
+			Pong:    60 * time.Second,
+			Collect: 30 * time.Second,
+		},
+		StateDir: "/var/lib/shm-agent",
+		HostRoot: "/",
+		Disk: DiskConfig{
+			ExcludeFSTypes: []string{"tmpfs", "devtmpfs", "ramfs", "overlay", "squashfs"},
+		},
+		DiskIO: DiskIOConfig{
+			ExcludeDevices: []string{"loop*", "ram*"},
+		},
+		AuthLog: AuthLogConfig{
+			Paths:               []string{"/var/log/auth.log", "/var/log/secure"},
+			BruteForceThreshold: 10,
+			BruteForceWindow:    5 * time.Minute,
+		},
+		Scanner: ScannerConfig{
+			Interval:    6 * time.Hour,
+			Concurrency: 2,
+			Timeout:     30 * time.Minute,
+			Arguments:   []string{"-sV"},
+		},
+		Firewall: FirewallConfig{Baseline: "/etc/shm-agent/firewall-baseline.json"},
+		Spool: SpoolConfig{
+			MaxSegmentBytes: defaultSpoolOptions.MaxSegmentBytes,
+			MaxBytes:        defaultSpoolOptions.MaxBytes,
+			MaxAge:          defaultSpoolOptions.MaxAge,
+		},
+	}
+}
+
+func (c *Config) spoolOptions() spoolOptions {
+	return spoolOptions{
+		MaxSegmentBytes: c.Spool.MaxSegmentBytes,
+		MaxBytes:        c.Spool.MaxBytes,
+		MaxAge:          c.Spool.MaxAge,
+	}
+}
+
+// collectorEnabled reports whether the named collector should run. Collectors
+// are on unless explicitly disabled.
+func (c *Config) collectorEnabled(name string) bool {
+	cc, ok := c.Collectors[name]
+	return !ok || cc.Enabled == nil || *cc.Enabled
+}
+
+// collectorInterval returns how often the collector runs, which is its
+// registered default unless the config overrides it.
+func (c *Config) collectorInterval(r registration) time.Duration {
+	if cc, ok := c.Collectors[r.Name()]; ok && cc.Interval != nil {
+		return *cc.Interval
+	}
+	return r.interval
+}
+
+// collectorTimeout returns how long one run of the named collector may take.
+func (c *Config) collectorTimeout(name string) time.Duration {
+	if cc, ok := c.Collectors[name]; ok && cc.Timeout > 0 {
+		return cc.Timeout
+	}
+	return c.Timeouts.Collect
+}
+
+// configError is one problem found in the configuration. Line is the line in
+// File the problem refers to, or 0 if it did not come from the file.
+type configError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e configError) Error() string {
+	switch {
+	case e.Line > 0 && e.File != "":
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// configErrors collects every problem found while loading a configuration.
+type configErrors []configError
+
+func (errs configErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// loadConfig parses the command-line flags in args and builds the resulting
+// configuration. The returned error is a configErrors when the configuration
+// itself is invalid.
+func loadConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	configPath := fs.String("config", defaultConfigPath, "path to the YAML config file (env SHM_CONFIG)")
+	serverURL := fs.String("server-url", "", "WebSocket URL of the server's /serverws endpoint")
+	agentName := fs.String("agent-name", "", "agent identity, used as the snapshot key (default: derived from hostname and machine-id)")
+	interval := fs.Duration("interval", 0, "how often a snapshot is sent")
+	sets := fs.String("collectors", "", "comma-separated collector sets to enable (base, debian)")
+	stateDir := fs.String("state-dir", "", "directory for the offline spool and other agent state")
+	hostRoot := fs.String("host-root", "", "where the host's root filesystem is mounted, e.g. /host in a container")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	path, explicit := *configPath, set["config"]
+	if env, ok := os.LookupEnv("SHM_CONFIG"); ok && !explicit {
+		path, explicit = env, true
+	}
+
+	cfg := defaultConfig()
+	root, err := loadConfigFile(path, &cfg, !explicit)
+	var errs configErrors
+	if err != nil && !errors.As(err, &errs) {
+		return nil, err
+	}
+
+	errs = append(errs, applyEnv(&cfg, os.LookupEnv)...)
+
+	if set["server-url"] {
+		cfg.Server.URL = *serverURL
+	}
+	if set["agent-name"] {
+		cfg.Agent.Name = *agentName
+	}
+	if set["interval"] {
+		cfg.Interval = *interval
+	}
+	if set["collectors"] {
+		cfg.CollectorSets = splitList(*sets)
+	}
+	if set["state-dir"] {
+		cfg.StateDir = *stateDir
+	}
+	if set["host-root"] {
+		cfg.HostRoot = *hostRoot
+	}
+
+	// Decoding errors leave the config half-filled, so only validate a
+	// cleanly decoded one.
+	if len(errs) == 0 {
+		errs = cfg.validate(root)
+	}
+	if len(errs) > 0 {
+		for i := range errs {
+			if errs[i].Line > 0 {
+				errs[i].File = path
+			}
+		}
+		return &cfg, errs
+	}
+	return &cfg, nil
+}
+
+var yamlLineRe = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// loadConfigFile decodes the YAML file at path over cfg and returns its node
+// tree, which validate uses to point errors at lines. A missing file is not
+// an error when allowMissing is set.
+func loadConfigFile(path string, cfg *Config, allowMissing bool) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if allowMissing && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, yamlErrors(err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return &root, yamlErrors(err)
+	}
+	return &root, nil
+}
+
+// yamlErrors converts a yaml.v3 error into configErrors with line numbers.
+func yamlErrors(err error) configErrors {
+	var msgs []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	} else {
+		msgs = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+
+	var errs configErrors
+	for _, msg := range msgs {
+		if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			errs = append(errs, configError{Line: line, Msg: m[2]})
+		} else {
+			errs = append(errs, configError{Msg: msg})
+		}
+	}
+	return errs
+}
+
+// applyEnv overrides cfg with SHM_* environment variables. Each scalar field
+// maps to SHM_ followed by its upper-cased YAML path, e.g. server.url is
+// SHM_SERVER_URL and timeouts.write is SHM_TIMEOUTS_WRITE. Lists are
+// comma-separated.
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) configErrors {
+	var errs configErrors
+	walkConfig(reflect.ValueOf(cfg).Elem(), "SHM", func(name string, v reflect.Value) {
+		raw, ok := lookup(name)
+		if !ok {
+			return
+		}
+		if err := setConfigValue(v, raw); err != nil {
+			errs = append(errs, configError{Msg: fmt.Sprintf("%s: %v", name, err)})
+		}
+	})
+	return errs
+}
+
+func walkConfig(v reflect.Value, prefix string, fn func(name string, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Struct:
+			walkConfig(field, name, fn)
+		case reflect.Map:
+			// Per-collector settings are only configurable in the file.
+		default:
+			fn(name, field)
+		}
+	}
+}
+
+func setConfigValue(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int64 || v.Kind() == reflect.Int:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(splitList(raw)))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// validate checks the semantic rules the YAML decoder cannot. root, when
+// non-nil, is used to attach file line numbers to the errors.
+func (c *Config) validate(root *yaml.Node) configErrors {
+	var errs configErrors
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, configError{Line: nodeLine(root, path), Msg: path + ": " + fmt.Sprintf(format, args...)})
+	}
+
+	if u, err := url.Parse(c.Server.URL); err != nil {
+		fail("server.url", "%v", err)
+	} else if u.Scheme != "ws" && u.Scheme != "wss" {
+		fail("server.url", "scheme must be ws or wss, got %q", c.Server.URL)
+	} else if u.Host == "" {
+		fail("server.url", "missing host in %q", c.Server.URL)
+	}
+
+	if c.Agent.Name != "" && !validIdentity(c.Agent.Name) {
+		fail("agent.name", "must be 1-%d letters, digits or underscores, got %q", maxIdentityLen, c.Agent.Name)
+	}
+	if c.Interval <= 0 {
+		fail("interval", "must be positive, got %s", c.Interval)
+	}
+
+	if c.Workers < 1 {
+		fail("workers", "must be at least 1, got %d", c.Workers)
+	}
+
+	if len(c.CollectorSets) == 0 {
+		fail("collector_sets", "at least one collector set must be enabled")
+	}
+	for _, set := range c.CollectorSets {
+		if _, ok := collectorSets[set]; !ok {
+			fail("collector_sets", "unknown collector set %q", set)
+		}
+	}
+	for name, cc := range c.Collectors {
+		if !collectorRegistered(name) {
+			fail("collectors."+name, "unknown collector %q", name)
+		}
+		if cc.Interval != nil && *cc.Interval < 0 {
+			fail("collectors."+name+".interval", "must not be negative, got %s", *cc.Interval)
+		}
+		if cc.Timeout < 0 {
+			fail("collectors."+name+".timeout", "must not be negative, got %s", cc.Timeout)
+		}
+	}
+
+	for path, d := range map[string]time.Duration{
+		"timeouts.dial":    c.Timeouts.Dial,
+		"timeouts.write":   c.Timeouts.Write,
+		"timeouts.pong":    c.Timeouts.Pong,
+		"timeouts.collect": c.Timeouts.Collect,
+	} {
+		if d <= 0 {
+			fail(path, "must be positive, got %s", d)
+		}
+	}
+
+	if c.StateDir == "" {
+		fail("state_dir", "must not be empty")
+	}
+	if c.HostRoot == "" {
+		fail("host_root", "must not be empty")
+	}
+	for path, patterns := range map[string][]string{
+		"disk.include_paths": c.Disk.IncludePaths,
+		"disk.exclude_paths": c.Disk.ExcludePaths,
+	} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, "/"); err != nil || !strings.HasPrefix(pattern, "/") {
+				fail(path, "%q is not an absolute path pattern", pattern)
+			}
+		}
+	}
+	for _, pattern := range c.DiskIO.ExcludeDevices {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			fail("disk_io.exclude_devices", "%q: %v", pattern, err)
+		}
+	}
+	if c.AuthLog.BruteForceThreshold < 1 {
+		fail("auth_log.brute_force_threshold", "must be at least 1, got %d", c.AuthLog.BruteForceThreshold)
+	}
+	if c.AuthLog.BruteForceWindow <= 0 {
+		fail("auth_log.brute_force_window", "must be positive, got %s", c.AuthLog.BruteForceWindow)
+	}
+	if c.Scanner.Enabled {
+		if len(c.Scanner.Targets) == 0 {
+			fail("scanner.targets", "at least one target is needed when the scanner is enabled")
+		}
+		for _, target := range c.Scanner.Targets {
+			if !validScanTarget(target) {
+				fail("scanner.targets", "%q is not an address, CIDR subnet or host name", target)
+			}
+		}
+		if c.Scanner.Interval <= 0 {
+			fail("scanner.interval", "must be positive, got %s", c.Scanner.Interval)
+		}
+		if c.Scanner.Concurrency < 1 {
+			fail("scanner.concurrency", "must be at least 1, got %d", c.Scanner.Concurrency)
+		}
+		if c.Scanner.Timeout <= 0 {
+			fail("scanner.timeout", "must be positive, got %s", c.Scanner.Timeout)
+		}
+		for _, arg := range c.Scanner.Arguments {
+			// The scanner reads nmap's XML from stdout and names the targets itself
+			if strings.HasPrefix(arg, "-o") || strings.HasPrefix(arg, "-i") || arg == "--resume" {
+				fail("scanner.arguments", "%q is not allowed; output and target options are set by the agent", arg)
+			}
+		}
+	}
+	if c.Firewall.Baseline != "" && !filepath.IsAbs(c.Firewall.Baseline) {
+		fail("firewall.baseline", "must be an absolute path, got %q", c.Firewall.Baseline)
+	}
+	if c.Helper.Socket != "" && !filepath.IsAbs(c.Helper.Socket) {
+		fail("helper.socket", "must be an absolute path, got %q", c.Helper.Socket)
+	}
+	for _, name := range c.Helper.AllowUsers {
+		if name == "" {
+			fail("helper.allow_users", "must not contain empty names")
+		}
+	}
+	if c.Spool.MaxSegmentBytes <= 0 {
+		fail("spool.max_segment_bytes", "must be positive")
+	}
+	if c.Spool.MaxBytes < c.Spool.MaxSegmentBytes {
+		fail("spool.max_bytes", "must be at least spool.max_segment_bytes")
+	}
+	if c.Spool.MaxAge < 0 {
+		fail("spool.max_age", "must not be negative")
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return errs
+}
+
+// nodeLine returns the line of the dotted path in the YAML tree, falling
+// back to the deepest parent found, or 0 without a tree.
+func nodeLine(root *yaml.Node, path string) int {
+	if root == nil {
+		return 0
+	}
+	node := root
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := 0
+	for _, key := range strings.Split(path, ".") {
+		if node.Kind != yaml.MappingNode {
+			return line
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				line = node.Content[i].Line
+				next = node.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return line
+		}
+		node = next
+	}
+	return line
+}
+
+var hostnameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+
+// validScanTarget accepts what may be handed to nmap as a target: an
+// address, a CIDR prefix or a host name, but nothing nmap would take for an
+// option.
+func validScanTarget(target string) bool {
+	if _, err := netip.ParseAddr(target); err == nil {
+		return true
+	}
+	if _, err := netip.ParsePrefix(target); err == nil {
+		return true
+	}
+	return len(target) <= 253 && hostnameRe.MatchString(target)
+}
+
+func collectorRegistered(name string) bool {
+	for _, registered := range collectorSets {
+		for _, c := range registered {
+			if c.Name() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func loadTestConfig(args ...string) (*Config, error) {
+	return loadConfig(flag.NewFlagSet("shm-agent", flag.ContinueOnError), args)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, `server:
+  url: wss://file.example/serverws
+interval: 10s
+workers: 2
+state_dir: /var/lib/from-file
+`)
+	t.Setenv("SHM_CONFIG", path)
+
+	// -config wins over SHM_CONFIG
+	other := writeConfig(t, "workers: 7\n")
+	cfg, err := loadTestConfig("-config", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers != 7 || cfg.Server.URL != defaultConfig().Server.URL {
+		t.Errorf("workers = %d, server.url = %s from -config", cfg.Workers, cfg.Server.URL)
+	}
+
+	t.Setenv("SHM_INTERVAL", "20s")
+	t.Setenv("SHM_WORKERS", "3")
+	cfg, err = loadTestConfig("-interval", "30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Timeouts.Dial != 45*time.Second {
+		t.Errorf("timeouts.dial = %s, want the default", cfg.Timeouts.Dial)
+	}
+	if cfg.Server.URL != "wss://file.example/serverws" || cfg.StateDir != "/var/lib/from-file" {
+		t.Errorf("server.url = %s, state_dir = %s, want the file's", cfg.Server.URL, cfg.StateDir)
+	}
+	if cfg.Workers != 3 {
+		t.Errorf("workers = %d, want SHM_WORKERS over the file", cfg.Workers)
+	}
+	if cfg.Interval != 30*time.Second {
+		t.Errorf("interval = %s, want the flag over SHM_INTERVAL and the file", cfg.Interval)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "config.yaml")
+	_, err := loadTestConfig("-config", missing)
+	var errs configErrors
+	if !errors.Is(err, os.ErrNotExist) || errors.As(err, &errs) {
+		t.Errorf("explicit missing config: %v", err)
+	}
+}
+
+func TestLoadConfigUnknownFields(t *testing.T) {
+	path := writeConfig(t, `interval: 5s
+sever:
+  url: wss://example.com/serverws
+workers: many
+`)
+	_, err := loadTestConfig("-config", path)
+	var errs configErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("loadConfig = %v, want configErrors", err)
+	}
+	if len(errs) != 2 || errs[0].Line != 2 || errs[1].Line != 4 || errs[0].File != path {
+		t.Fatalf("errors %+v", errs)
+	}
+	if !strings.Contains(errs[0].Msg, "field sever not found") {
+		t.Errorf("unknown field: %s", errs[0].Msg)
+	}
+	if !strings.HasPrefix(err.Error(), path+":2: ") {
+		t.Errorf("error %q does not start with the file and line", err)
+	}
+}
+
+func TestConfigValidateLines(t *testing.T) {
+	path := writeConfig(t, `server:
+  url: http://example.com/serverws
+interval: 5s
+collectors:
+  no_such_collector:
+    interval: 1m
+  memory:
+    timeout: -1s
+timeouts:
+  dial: 0s
+`)
+	t.Setenv("SHM_WORKERS", "0")
+	_, err := loadTestConfig("-config", path)
+	var errs configErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("loadConfig = %v, want configErrors", err)
+	}
+	want := []struct {
+		line   int
+		prefix string
+	}{
+		{0, "workers:"}, // from the environment, not the file
+		{2, "server.url:"},
+		{5, "collectors.no_such_collector:"},
+		{8, "collectors.memory.timeout:"},
+		{10, "timeouts.dial:"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors %v", errs)
+	}
+	for i, w := range want {
+		if errs[i].Line != w.line || !strings.HasPrefix(errs[i].Msg, w.prefix) {
+			t.Errorf("error %d = line %d %q, want line %d %s", i, errs[i].Line, errs[i].Msg, w.line, w.prefix)
+		}
+	}
+	if errs[0].File != "" || errs[1].File != path {
+		t.Errorf("files %q, %q", errs[0].File, errs[1].File)
+	}
+}
+
+func TestNodeLine(t *testing.T) {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte("server:\n  url: ws://x/\ncollectors:\n  memory:\n    interval: 1m\n"), &root); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		line int
+	}{
+		{"server.url", 2},
+		{"collectors.memory.interval", 5},
+		{"collectors.memory.timeout", 4}, // falls back to the parent
+		{"server.url.scheme", 2},         // below a scalar
+		{"agent.name", 0},
+	}
+	for _, tt := range tests {
+		if got := nodeLine(&root, tt.path); got != tt.line {
+			t.Errorf("nodeLine(%s) = %d, want %d", tt.path, got, tt.line)
+		}
+	}
+	if got := nodeLine(nil, "server.url"); got != 0 {
+		t.Errorf("nodeLine without a tree = %d", got)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	minBackoff = 1 * time.Second
+	maxBackoff = 2 * time.Minute
+)
+
+var errNotConnected = errors.New("not connected to server")
+
+// backoff computes capped exponential delays with jitter between redials.
+type backoff struct {
+	min, max time.Duration
+	attempt  int
+}
+
+// next returns the delay before the next attempt: a random duration between
+// half and all of min*2^attempt, capped at max.
+func (b *backoff) next() time.Duration {
+	d := b.max
+	if b.attempt < 32 {
+		if exp := b.min << uint(b.attempt); exp > 0 && exp < b.max {
+			d = exp
+		}
+	}
+	b.attempt++
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// connManager keeps a WebSocket connection to the server open, redialling
+// with backoff whenever a write fails or the server stops answering pings.
+type connManager struct {
+	url       string
+	dialer    *websocket.Dialer
+	writeWait time.Duration
+	pongWait  time.Duration
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	broken chan struct{} // closed when the current conn must be replaced
+}
+
+func newConnManager(url string, timeouts TimeoutConfig) *connManager {
+	return &connManager{
+		url: url,
+		dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: timeouts.Dial,
+		},
+		writeWait: timeouts.Write,
+		pongWait:  timeouts.Pong,
+	}
+}
+
+// Run dials the server and keeps the connection alive until ctx is done.
+func (m *connManager) Run(ctx context.Context) {
+	b := backoff{min: minBackoff, max: maxBackoff}
+	for {
+		conn, _, err := m.dialer.DialContext(ctx, m.url, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			delay := b.next()
+			log.Printf("Error connecting to WebSocket: %v (retrying in %s)", err, delay.Round(time.Millisecond))
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		log.Println("Connected to", m.url)
+		connected := time.Now()
+
+		broken := m.attach(conn)
+		go m.readLoop(conn)
+		m.keepAlive(ctx, conn, broken)
+		m.detach(conn)
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := m.redialDelay(&b, time.Since(connected))
+		log.Printf("Reconnecting in %s", delay.Round(time.Millisecond))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// redialDelay returns how long to wait before redialling after a connection
+// that lasted the given time. A server that accepts the handshake and then
+// drops the connection (one half up during a redeploy) must not be redialled
+// in a tight loop, so the backoff is only reset once a connection has lasted
+// a full pong interval, and applies to broken connections too.
+func (m *connManager) redialDelay(b *backoff, lasted time.Duration) time.Duration {
+	if lasted >= m.pongWait {
+		b.reset()
+	}
+	return b.next()
+}
+
+// Send writes one text message. It returns errNotConnected while the manager
+// is between connections, and marks the connection broken on write errors.
+func (m *connManager) Send(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == nil {
+		return errNotConnected
+	}
+	m.conn.SetWriteDeadline(time.Now().Add(m.writeWait))
+	if err := m.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		m.markBrokenLocked()
+		return err
+	}
+	return nil
+}
+
+func (m *connManager) attach(conn *websocket.Conn) chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conn = conn
+	m.broken = make(chan struct{})
+	return m.broken
+}
+
+func (m *connManager) detach(conn *websocket.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == conn {
+		m.conn = nil
+	}
+	conn.Close()
+}
+
+func (m *connManager) markBroken(conn *websocket.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn == conn {
+		m.markBrokenLocked()
+	}
+}
+
+func (m *connManager) markBrokenLocked() {
+	select {
+	case <-m.broken:
+	default:
+		close(m.broken)
+	}
+}
+
+// readLoop drains messages from the server so control frames are handled,
+// and treats a missed pong (read deadline) as a broken connection.
+func (m *connManager) readLoop(conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(m.pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(m.pongWait))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			log.Println("WebSocket connection lost:", err)
+			m.markBroken(conn)
+			return
+		}
+	}
+}
+
+// keepAlive pings the server until the connection breaks or ctx is done.
+func (m *connManager) keepAlive(ctx context.Context, conn *websocket.Conn, broken chan struct{}) {
+	ticker := time.NewTicker(m.pongWait * 9 / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(m.writeWait)); err != nil {
+				log.Println("Error sending ping:", err)
+				m.markBroken(conn)
+			}
+		case <-broken:
+			return
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(m.writeWait))
+			return
+		}
+	}
+}
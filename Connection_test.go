@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// checkDelay checks that d is a jittered delay for base: between half and all
+// of it.
+func checkDelay(t *testing.T, what string, d, base time.Duration) {
+	t.Helper()
+	if d < base/2 || d > base {
+		t.Errorf("%s: delay %s, want %s to %s", what, d, base/2, base)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := backoff{min: time.Second, max: time.Minute}
+	base := time.Second
+	for attempt := 0; attempt < 100; attempt++ {
+		checkDelay(t, fmt.Sprintf("attempt %d", attempt), b.next(), base)
+		if base *= 2; base > time.Minute {
+			base = time.Minute
+		}
+	}
+
+	// at the cap the delays still differ, so agents do not redial in step
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		seen[b.next()] = true
+	}
+	if len(seen) < 2 {
+		t.Error("no jitter at the cap")
+	}
+
+	b.reset()
+	checkDelay(t, "after reset", b.next(), time.Second)
+}
+
+func TestRedialDelay(t *testing.T) {
+	m := &connManager{pongWait: time.Minute}
+	b := backoff{min: time.Second, max: time.Hour}
+	for i := 0; i < 5; i++ {
+		b.next()
+	}
+	// dropped right after the handshake: the backoff keeps growing
+	checkDelay(t, "short connection", m.redialDelay(&b, time.Second), 32*time.Second)
+	checkDelay(t, "another short connection", m.redialDelay(&b, m.pongWait-time.Millisecond), 64*time.Second)
+	// lasted a full pong interval: it starts over
+	checkDelay(t, "long connection", m.redialDelay(&b, m.pongWait), time.Second)
+}
+
+func TestSend(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	}))
+	defer srv.Close()
+
+	m := newConnManager("ws"+strings.TrimPrefix(srv.URL, "http"), defaultConfig().Timeouts)
+	if err := m.Send([]byte("early")); !errors.Is(err, errNotConnected) {
+		t.Fatalf("Send before connecting = %v, want errNotConnected", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(stopped)
+	}()
+	waitFor(t, "the connection", func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return m.conn != nil
+	})
+	if err := m.Send([]byte("snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "snapshot" {
+		t.Errorf("server received %q", got)
+	}
+
+	cancel()
+	<-stopped
+	if err := m.Send([]byte("late")); !errors.Is(err, errNotConnected) {
+		t.Errorf("Send after Run returned = %v, want errNotConnected", err)
+	}
+}
@@ -7,6 +7,8 @@ import java.sql.SQLException;
 import java.sql.Statement;
 import java.util.ArrayList;
 import java.util.List;
+import java.util.Set;
+import java.util.concurrent.ConcurrentHashMap;
 
 import org.json.JSONArray;
 import org.json.JSONObject;
@@ -25,6 +27,8 @@ public class DBFunction {
 				// Step 2: Create the table if it does not exist
 				// createTable(connection, key);
 				createTable(connection, key);
+			} else if (checkedTables.add(key)) {
+				widenContext(connection, key);
 			}
 
 			// Step 3: Insert the JSON data into the table
@@ -38,11 +42,31 @@ public class DBFunction {
 
 	private static void createTable(Connection connection, String tableName) throws SQLException {
 		// Create a table with id and context (to store JSON data)
+		// MEDIUMTEXT holds up to 16 MiB; a snapshot with many events or ports
+		// does not fit the 64 KiB of TEXT
 		String createTableSQL = String.format("CREATE TABLE `%s` (" + "`id` INT NOT NULL AUTO_INCREMENT,"
-				+ "`context` TEXT NOT NULL," + "PRIMARY KEY (`id`)" + ")", tableName);
+				+ "`context` MEDIUMTEXT NOT NULL," + "PRIMARY KEY (`id`)" + ")", tableName);
 		try (Statement stmt = connection.createStatement()) {
 			stmt.execute(createTableSQL);
 		}
+		checkedTables.add(tableName);
+	}
+
+	// Tables already checked for the column type since the server started
+	private static final Set<String> checkedTables = ConcurrentHashMap.newKeySet();
+
+	private static void widenContext(Connection connection, String tableName) throws SQLException {
+		// Tables created before the switch to MEDIUMTEXT still have a TEXT column
+		String query = String.format("SHOW COLUMNS FROM `%s` LIKE 'context'", tableName);
+		try (Statement stmt = connection.createStatement(); ResultSet rs = stmt.executeQuery(query)) {
+			if (!rs.next() || !rs.getString("Type").equalsIgnoreCase("text")) {
+				return;
+			}
+		}
+		String alterSQL = String.format("ALTER TABLE `%s` MODIFY `context` MEDIUMTEXT NOT NULL", tableName);
+		try (Statement stmt = connection.createStatement()) {
+			stmt.execute(alterSQL);
+		}
 	}
 
 	private static boolean tableExists(Connection connection, String tableName) throws SQLException {
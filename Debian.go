@@ -1,535 +1,327 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
-	"net"
-	"os/exec"
-	"regexp"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
-
-	"github.com/gorilla/websocket"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/host"
-	"github.com/shirou/gopsutil/v3/mem"
 )
 
-type SystemInfo struct {
-	DiskUsage      string `json:diskusage`
-	Bluetoothuse   string `json:bluetoothuse`
-	OsName         string `json:"OperatingSystem"`
-	HardwareModel  string `json:"HardwareModel"`
-	HardwareVendor string `json:HardwareVendor`
-	Firewallstatus string `json:firewallstatus`
-	NmapScan       string `json:"nmap_scan"`
-	Hostname       string `json:"hostname"`
-	IP             string `json:"ip"`
-	CPUModel       string `json:"cpu_model"`
-	TotalMemory    string `json:"total_memory"`
-	UsedMemory     string `json:"used_memory"`
-	Uptime         string `json:"uptime"`
-	WiFi           string `json:"wifi"`
-	Battery        string `json:"battery"`
-	SSHInfo        string `json:"ssh_info"`
-	Timestamp      string `json:"timestamp"`
+// The Debian extras are an optional collector set, enabled with
+// -collectors base,debian. Their display strings keep the keys the old
+// Debian build emitted.
+func init() {
+	registerCollector("debian", &diskCollector{}, time.Minute)
+	register("debian", "bluetooth", 30*time.Second, getblueusage)
+	register("debian", "os", runOnce, getOSType)
+	register("debian", "hardware_model", runOnce, gethardwareModel)
+	register("debian", "hardware_vendor", runOnce, getVendorType)
+	registerCollector("debian", &firewallCollector{}, time.Minute)
+	register("debian", "ethernet", time.Minute, getEthernetInfo)
+
+	privilegedCollectors["firewall"] = "reading the nftables or iptables ruleset needs root; configure the helper to run it"
+
+	legacyDisplayKeys["os"] = "OperatingSystem"
+	legacyDisplayKeys["hardware_model"] = "HardwareModel"
+	legacyDisplayKeys["hardware_vendor"] = "HardwareVendor"
 }
 
-type SystemInfoWrapper struct {
-	System1Info SystemInfo `json:"Thangavi"`
+// DiskUsage is the usage of each mounted filesystem, and their total.
+type DiskUsage struct {
+	TotalBytes     uint64       `json:"total_bytes"`
+	UsedBytes      uint64       `json:"used_bytes"`
+	AvailableBytes uint64       `json:"available_bytes"`
+	UsedPercent    float64      `json:"used_percent"`
+	Filesystems    []Filesystem `json:"filesystems"`
 }
 
-func gethardwareModel() (string, error) {
-	out, err := exec.Command("sudo", "dmidecode", "-s", "system-product-name").Output()
-	if err != nil {
-		return "", fmt.Errorf("hardwareModel information command not found")
-	}
-	hardwareModel := strings.TrimSpace(string(out))
-	return hardwareModel, nil
+// Filesystem is one mounted filesystem. FreeBytes includes the blocks
+// reserved for root; AvailableBytes, and so UsedPercent, do not.
+type Filesystem struct {
+	MountPoint     string  `json:"mount_point"`
+	Device         string  `json:"device"`
+	FSType         string  `json:"fstype"`
+	ReadOnly       bool    `json:"read_only"`
+	TotalBytes     uint64  `json:"total_bytes"`
+	UsedBytes      uint64  `json:"used_bytes"`
+	FreeBytes      uint64  `json:"free_bytes"`
+	AvailableBytes uint64  `json:"available_bytes"`
+	UsedPercent    float64 `json:"used_percent"`
+	InodesTotal    uint64  `json:"inodes_total"`
+	InodesUsed     uint64  `json:"inodes_used"`
+}
 
+func (d DiskUsage) display() map[string]string {
+	return map[string]string{"DiskUsage": fmt.Sprintf("Total Space: %s | Used Space: %s | Available Space: %s | Percentage of Use: %.0f%%",
+		humanBytes(d.TotalBytes), humanBytes(d.UsedBytes), humanBytes(d.AvailableBytes), d.UsedPercent)}
 }
 
-func getOSType() (string, error) {
-	out, err := exec.Command("hostnamectl").Output()
-	if err != nil {
-		return "", fmt.Errorf("OS information command not found")
-	}
+// Bluetooth is the state of the Bluetooth controllers.
+type Bluetooth struct {
+	Powered bool `json:"powered"`
+}
 
-	osdetail := string(out)
-	if strings.Contains(osdetail, "Operating System") {
-		lines := strings.Split(osdetail, "\n")
-		for _, line := range lines {
-			if strings.Contains(line, "Operating System") {
-				parts := strings.Split(line, ":")
-				//fmt.Printf(parts[0])
-				//fmt.Printf(parts[1])
-				if len(parts) > 1 {
-					// Return only the value part, trimming any extra spaces
-					return strings.TrimSpace(parts[1]), nil
-				}
-			}
-		}
+func (b Bluetooth) display() map[string]string {
+	if b.Powered {
+		return map[string]string{"Bluetoothuse": "ON"}
 	}
-
-	return "No OS info available", nil
+	return map[string]string{"Bluetoothuse": "OFF"}
 }
-func getfirewall() (string, error) {
-	out, err := exec.Command("sudo", "ufw", "status").CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("firewall not giving status: %v", err)
-	}
 
-	status := string(out)
-	if strings.Contains(status, "Status") {
-		// Split the output into lines
-		lines := strings.Split(status, "\n")
-		for _, line := range lines {
-			// Check for the line containing "Status"
-			if strings.HasPrefix(line, "Status:") {
-				// Split the line by ":"
-				parts := strings.Split(line, ":")
-				if len(parts) > 1 {
-					return strings.TrimSpace(parts[1]), nil // Trim spaces around the value
-				}
-			}
-		}
-	}
+// Ethernet is the first wired network interface.
+type Ethernet struct {
+	Interface string `json:"interface"`
+}
 
-	return "No status info available", nil
+func (e Ethernet) display() map[string]string {
+	return map[string]string{"ethernet": " " + e.Interface + ":link/ether"}
 }
 
-func getVendorType() (string, error) {
-	out, err := exec.Command("sudo", "dmidecode", "-s", "system-manufacturer").Output()
-	if err != nil {
-		return "", fmt.Errorf("vendortype information command not found")
-	}
-	vendortype := strings.TrimSpace(string(out))
-	return vendortype, nil
+// gethardwareModel reads the DMI product name, which the kernel exposes to
+// every user (dmidecode needs root to read the same table).
+func gethardwareModel(ctx context.Context) (string, error) {
+	return readDMI("product_name")
 }
 
-func getCPUModel() (string, error) {
-	cpuInfo, err := cpu.Info()
+func getOSType(ctx context.Context) (string, error) {
+	release, err := readOSRelease()
 	if err != nil {
 		return "", err
 	}
-	if len(cpuInfo) > 0 {
-		return cpuInfo[0].ModelName, nil
+	// PRETTY_NAME is what hostnamectl shows as "Operating System"
+	if name := release["PRETTY_NAME"]; name != "" {
+		return name, nil
 	}
-	return "Unknown", nil
-}
-func getblueusage() (string, error) {
-	// Run the hciconfig command to check the Bluetooth status
-	cmd := exec.Command("hciconfig")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
-	if err != nil {
-		return "Error checking Bluetooth", err
+	if name := release["NAME"]; name != "" {
+		return name, nil
 	}
+	return "", unavailable("os-release does not name the operating system")
+}
 
-	// Convert output to string
-	output := out.String()
+func getVendorType(ctx context.Context) (string, error) {
+	return readDMI("sys_vendor")
+}
 
-	// Check if there is a Bluetooth device present (look for hci interface)
-	if !strings.Contains(output, "hci") {
-		return "NO BLUETOOTH DEVICE", nil // No Bluetooth device found
+// readDMI returns a field of the DMI system information. Virtual machines
+// and boards without DMI tables have no /sys/class/dmi.
+func readDMI(field string) (string, error) {
+	value, err := readHostFile("/sys/class/dmi/id/" + field)
+	if err != nil {
+		return "", err
 	}
-
-	// Check if Bluetooth is DOWN (off)
-	if strings.Contains(output, "DOWN") {
-		return "OFF", nil // Bluetooth is off
+	if value == "" {
+		return "", unavailable("DMI %s is empty", field)
 	}
-
-	// If not DOWN, consider Bluetooth as ON
-	return "ON", nil // Bluetooth is on
+	return value, nil
 }
-func getDiskUsage() (string, error) {
-	// Execute the df -h --total command
-	out, err := exec.Command("df", "-h", "--total").Output()
+
+func getblueusage(ctx context.Context) (Bluetooth, error) {
+	// Each controller is an hciN entry; its connections show up there too, as hciN:M
+	adapters, err := filepath.Glob(hostPath("/sys/class/bluetooth/hci*"))
 	if err != nil {
-		return "", fmt.Errorf("failed to get disk usage: %v", err)
+		return Bluetooth{}, err
 	}
 
-	// Convert the output to a string
-	output := string(out)
-
-	// Split the output into lines
-	lines := strings.Split(output, "\n")
-
-	// Look for the line that contains 'total'
-	for _, line := range lines {
-		if strings.Contains(line, "total") {
-			// Split the line into fields by spaces
-			fields := strings.Fields(line)
-
-			// Check if the output has at least 5 fields (Size, Used, Avail, Use%)
-			if len(fields) >= 5 {
-				totalSpace := fields[1]
-				usedSpace := fields[2]
-				availableSpace := fields[3]
-				percentageUsed := fields[4]
-
-				// Format the output without newlines
-				result := fmt.Sprintf("Total Space: %s | Used Space: %s | Available Space: %s | Percentage of Use: %s",
-					totalSpace, usedSpace, availableSpace, percentageUsed)
-
-				// Remove any newlines if they exist (though they shouldn't in this format)
-				result = strings.ReplaceAll(result, "\n", "")
+	found := false
+	for _, adapter := range adapters {
+		if strings.Contains(filepath.Base(adapter), ":") {
+			continue
+		}
+		found = true
 
-				return result, nil
+		// A controller without an rfkill switch cannot be turned off by one;
+		// otherwise state 1 means unblocked, i.e. powered.
+		states, _ := filepath.Glob(filepath.Join(adapter, "rfkill*", "state"))
+		if len(states) == 0 {
+			return Bluetooth{Powered: true}, nil
+		}
+		for _, state := range states {
+			if data, err := os.ReadFile(state); err == nil && strings.TrimSpace(string(data)) == "1" {
+				return Bluetooth{Powered: true}, nil
 			}
 		}
 	}
+	if !found {
+		return Bluetooth{}, unavailable("no Bluetooth device")
+	}
+	return Bluetooth{Powered: false}, nil
+}
 
-	return "", fmt.Errorf("total disk usage information not found")
+// diskCollector reports the usage of each mounted filesystem that passes
+// the disk filters, and their total. A device mounted more than once (bind
+// mounts, btrfs subvolumes) is counted at its first mount point only.
+type diskCollector struct {
+	filter mountFilter
 }
 
-func getMemoryInfo() (string, string, error) {
-	vmStat, err := mem.VirtualMemory()
-	if err != nil {
-		return "", "", err
-	}
-	totalMem := fmt.Sprintf("%.2f GB", float64(vmStat.Total)/(1024*1024*1024)) // covert gb
-	usedMem := fmt.Sprintf("%.2f GB (%.2f%%)", float64(vmStat.Used)/(1024*1024*1024), vmStat.UsedPercent)
-	return totalMem, usedMem, nil
+func (c *diskCollector) Name() string {
+	return "disk"
 }
 
-func getUptime() (string, error) {
-	uptime, err := host.Uptime()
-	if err != nil {
-		return "", err
+func (c *diskCollector) configure(cfg *Config) error {
+	c.filter = mountFilter{
+		includeTypes: cfg.Disk.IncludeFSTypes,
+		excludeTypes: cfg.Disk.ExcludeFSTypes,
+		includePaths: cfg.Disk.IncludePaths,
+		excludePaths: cfg.Disk.ExcludePaths,
 	}
-	uptimeDuration := time.Duration(uptime) * time.Second
-	return uptimeDuration.String(), nil
+	return nil
 }
 
-func getWiFiInfo() (string, error) {
-	// Execute the `ip addr` command to get network interface information
-	out, err := exec.Command("ip", "addr").Output()
+func (c *diskCollector) Collect(ctx context.Context) (interface{}, error) {
+	mounts, err := readMounts()
 	if err != nil {
-		return "", fmt.Errorf("Failed to retrieve network information")
+		return DiskUsage{}, err
 	}
 
-	networkInfo := string(out)
-
-	// Search for the Ethernet interface (enp0s3) and link/ether
-	if strings.Contains(networkInfo, "enp0s3") {
-		lines := strings.Split(networkInfo, "\n")
-		for i, line := range lines {
-			// Find the line with "enp0s3"
-			if strings.Contains(line, "enp0s3") {
-				// Search for the "link/ether" information on the following lines
-				for j := i; j < len(lines); j++ {
-					if strings.Contains(lines[j], "link/ether") {
-						// Return the exact string "wifi":"link/ether"
-						return " enp0s3:link/ether", nil
-					}
-				}
+	usage := DiskUsage{Filesystems: []Filesystem{}}
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		if !c.filter.match(m) {
+			continue
+		}
+		if strings.HasPrefix(m.Device, "/") {
+			if seen[m.Device] {
+				continue
 			}
+			seen[m.Device] = true
 		}
-	}
-
-	return "", fmt.Errorf("No Ethernet info available")
-}
-func getBatteryInfo() (string, error) {
-	out, err := exec.Command("upower", "-i", "/org/freedesktop/UPower/devices/battery_BAT0").Output()
-	if err != nil {
-		return "", fmt.Errorf("Battery information command not found")
-	}
-
-	lines := strings.Split(string(out), "\n")
-	var batteryInfo []string
-	var state, timeToEmpty, timeToFull, percentage string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line) // Clean up whitespace
-		if strings.Contains(line, "state") {
-			state = line
-		} else if strings.Contains(line, "time to empty") {
-			timeToEmpty = line
-		} else if strings.Contains(line, "time to full") {
-			timeToFull = line
-		} else if strings.Contains(line, "percentage") {
-			percentage = line
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(hostPath(m.Point), &st); err != nil || st.Blocks == 0 {
+			continue
 		}
-	}
+		size := uint64(st.Frsize)
+		fs := Filesystem{
+			MountPoint:     m.Point,
+			Device:         m.Device,
+			FSType:         m.FSType,
+			ReadOnly:       hasOption(m.Options, "ro"),
+			TotalBytes:     st.Blocks * size,
+			UsedBytes:      (st.Blocks - st.Bfree) * size,
+			FreeBytes:      st.Bfree * size,
+			AvailableBytes: st.Bavail * size,
+			InodesTotal:    st.Files,
+			InodesUsed:     st.Files - st.Ffree,
+		}
+		fs.UsedPercent = dfPercent(fs.UsedBytes, fs.AvailableBytes)
+		usage.Filesystems = append(usage.Filesystems, fs)
 
-	batteryInfo = append(batteryInfo, state)
-	if timeToFull != "" {
-		batteryInfo = append(batteryInfo, timeToFull)
-	} else if timeToEmpty != "" {
-		batteryInfo = append(batteryInfo, timeToEmpty)
-	}
-	if percentage != "" {
-		batteryInfo = append(batteryInfo, percentage)
+		usage.TotalBytes += fs.TotalBytes
+		usage.UsedBytes += fs.UsedBytes
+		usage.AvailableBytes += fs.AvailableBytes
 	}
-
-	if len(batteryInfo) > 0 {
-		return strings.Join(batteryInfo, ", "), nil
+	if usage.TotalBytes == 0 {
+		return DiskUsage{}, unavailable("no mounted filesystems")
 	}
-	return "No Battery info available", nil
+	usage.UsedPercent = dfPercent(usage.UsedBytes, usage.AvailableBytes)
+	return usage, nil
 }
 
-func getSSHInfo() (string, error) {
-	out, err := exec.Command("ss", "-tuna").Output()
-	if err != nil {
-		return "", fmt.Errorf("SSH command not found")
+// dfPercent is the used share the way df computes it: relative to the space
+// available to unprivileged users, rounded up.
+func dfPercent(used, available uint64) float64 {
+	if used+available == 0 {
+		return 0
 	}
+	return math.Ceil(float64(used) * 100 / float64(used+available))
+}
 
-	ssOut := string(out)
-	var connectedIPs []string
-	lines := strings.Split(ssOut, "\n")
-
-	for _, line := range lines {
-		if strings.Contains(line, ":22") && strings.Contains(line, "ESTAB") {
-			parts := strings.Fields(line)
-			if len(parts) >= 5 {
-				remoteAddress := parts[len(parts)-1]
-				ip := strings.Split(remoteAddress, ":")[0]
-				if ip != "127.0.0.1" && ip != "localhost" {
-					connectedIPs = append(connectedIPs, ip)
-				}
-			}
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
 		}
 	}
-
-	if len(connectedIPs) > 0 {
-		return fmt.Sprintf("Active SSH connections detected. Connected IPs: %s", strings.Join(connectedIPs, ", ")), nil
-	}
-	return "No active SSH connections", nil
+	return false
 }
 
-// Check if nmap is installed
-
-// Function to perform vulnerability scan
+// mountFilter selects the mounts the disk collector reports. Empty include
+// lists include everything; excludes win over includes. Path patterns are
+// shell globs matching a mount point or any directory above it, so
+// "/var/lib/docker" also covers everything mounted below it.
+type mountFilter struct {
+	includeTypes, excludeTypes []string
+	includePaths, excludePaths []string
+}
 
-// Function to get the system IP address
-func getIPAddress() (string, error) {
-	interfaces, err := net.Interfaces()
-	if err != nil {
-		return "", err
+func (f mountFilter) match(m mount) bool {
+	if len(f.includeTypes) > 0 && !containsString(f.includeTypes, m.FSType) {
+		return false
 	}
-
-	for _, iface := range interfaces {
-		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
-			addrs, err := iface.Addrs()
-			if err != nil {
-				return "", err
-			}
-			for _, addr := range addrs {
-				var ip net.IP
-				switch v := addr.(type) {
-				case *net.IPNet:
-					ip = v.IP
-				case *net.IPAddr:
-					ip = v.IP
-				}
-				if ip != nil && ip.To4() != nil {
-					return ip.String(), nil
-				}
-			}
-		}
+	if containsString(f.excludeTypes, m.FSType) {
+		return false
 	}
-	return "No IP address found", nil
-}
-func checkAndInstallNmap() error {
-	_, err := exec.LookPath("nmap")
-	if err != nil {
-		fmt.Println("Nmap is not installed. Installing...")
-		cmd := exec.Command("sudo", "apt", "install", "-y", "nmap")
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to install nmap: %w", err)
-		}
-		fmt.Println("Nmap has been installed.")
+	if len(f.includePaths) > 0 && !matchMountPath(f.includePaths, m.Point) {
+		return false
 	}
-	return nil
+	return !matchMountPath(f.excludePaths, m.Point)
 }
-func getNmapScan() (string, error) {
-	cmd := exec.Command("sudo", "nmap", "-sT", "-O", "localhost")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("Error executing Nmap: %v", err)
-	}
 
-	// Use a scanner to read the Nmap output line by line
-	scanner := bufio.NewScanner(strings.NewReader(string(output)))
-	var nmapResults []string
-	re := regexp.MustCompile(`^([0-9]+)/tcp\s+(\S+)\s+(\S+)`)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if matches := re.FindStringSubmatch(line); matches != nil {
-			// Extract port, state, and service
-			port := matches[1]
-			state := matches[2]
-			service := matches[3]
-			nmapResults = append(nmapResults, fmt.Sprintf("Port: %s, State: %s, Service: %s", port, state, service))
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
 		}
 	}
+	return false
+}
 
-	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("Error reading Nmap output: %v", err)
-	}
-
-	if len(nmapResults) == 0 {
-		return "No open ports detected", nil
+func matchMountPath(patterns []string, point string) bool {
+	for _, pattern := range patterns {
+		for dir := point; ; dir = filepath.Dir(dir) {
+			if ok, _ := filepath.Match(pattern, dir); ok {
+				return true
+			}
+			if dir == "/" {
+				break
+			}
+		}
 	}
-	return strings.Join(nmapResults, "; "), nil
+	return false
 }
 
-func main() {
-	// Check and install nmap if not installed
-	if err := checkAndInstallNmap(); err != nil {
-		log.Fatal(err)
-	}
-	// WebSocket connection setup
-	wsURL := "ws://192.168.11.194:8080/SystemMonitoring/serverws" // Replace with your actual WebSocket server URL
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+// getEthernetInfo reports the first wired network interface: one backed by
+// a device, of Ethernet type (ARPHRD_ETHER) and not wireless.
+func getEthernetInfo(ctx context.Context) (Ethernet, error) {
+	entries, err := os.ReadDir(hostPath("/sys/class/net"))
 	if err != nil {
-		log.Fatal("Error connecting to WebSocket:", err)
+		return Ethernet{}, err
 	}
-	defer conn.Close()
-
-	// Infinite loop to update system information every 1 minute
-	for {
-
-		nmap, err := getNmapScan()
-		fmt.Println(nmap)
-		if err != nil {
-			log.Fatal("Error getting host info:", err)
-		}
-
-		bluetoothuse, err := getblueusage()
-		if err != nil {
-			panic(err)
-		}
-		println("Bluetooth Status:", bluetoothuse)
-
-		// Now, you can use the bluetoothuse variable
-		//println("Bluetooth Status:", bluetoothuse)
-		diskuse, err := getDiskUsage()
-		if err != nil {
-			log.Fatal("Error getting host info:", err)
-		}
-
-		typc, err := gethardwareModel()
-		if err != nil {
-			log.Fatal("Error getting host info:", err)
-		}
-
-		firewalstatus, err := getfirewall()
-		if err != nil {
-			log.Fatal("Error getting host info:", err)
+	for _, e := range entries {
+		dir := "/sys/class/net/" + e.Name()
+		if typ, err := readHostInt(dir + "/type"); err != nil || typ != 1 {
+			continue
 		}
-		vendor, err := getVendorType()
-		if err != nil {
-			log.Fatal("Error getting host info:", err)
+		if _, err := os.Stat(hostPath(dir + "/device")); err != nil {
+			continue // virtual: bridge, veth, tun, ...
 		}
-
-		typcc, err := getOSType()
-		if err != nil {
-			log.Fatal("Error getting host info:", err)
-		}
-
-		// Get Hostname
-		hostInfo, err := host.Info()
-		if err != nil {
-			log.Fatal("Error getting host info:", err)
-		}
-		hostname := hostInfo.Hostname
-
-		// Get IP Address
-		ipAddress, err := getIPAddress()
-		if err != nil {
-			log.Fatal("Error getting IP address:", err)
-
-		}
-
-		// Get CPU model
-		cpuModel, err := getCPUModel()
-		if err != nil {
-			log.Fatal("Error getting CPU info:", err)
-		}
-
-		// Get Memory info
-		totalMem, usedMem, err := getMemoryInfo()
-		fmt.Println(totalMem)
-		if err != nil {
-			log.Fatal("Error getting memory info:", err)
-		}
-
-		// Get System Uptime
-		uptime, err := getUptime()
-		if err != nil {
-			log.Fatal("Error getting uptime info:", err)
-		}
-
-		// Get WiFi info
-		wifi, err := getWiFiInfo()
-		if err != nil {
-			log.Println(err)
-			wifi = "No WiFi info"
-		}
-		// Get Battery info
-		battery, err := getBatteryInfo()
-		if err != nil {
-			log.Println(err)
-			battery = "No Battery info"
-		}
-
-		// Get SSH info
-		ssh, err := getSSHInfo()
-		if err != nil {
-			log.Println(err)
-			ssh = "No SSH info"
-		}
-
-		// Get Current Time
-		currentTime := time.Now().Format(time.RFC3339)
-
-		// Create a struct for system information
-		sysInfo := SystemInfo{
-			Hostname:       hostname,
-			IP:             ipAddress,
-			CPUModel:       cpuModel,
-			TotalMemory:    totalMem,
-			UsedMemory:     usedMem,
-			Uptime:         uptime,
-			WiFi:           wifi,
-			Battery:        battery,
-			SSHInfo:        ssh,
-			Timestamp:      currentTime,
-			OsName:         typcc,
-			HardwareModel:  typc,
-			HardwareVendor: vendor,
-			Firewallstatus: firewalstatus,
-			NmapScan:       nmap,
-			Bluetoothuse:   bluetoothuse,
-			DiskUsage:      diskuse,
-		}
-
-		// Wrap system info inside SystemInfoWrapper with the "system1_info" key
-		wrappedInfo := SystemInfoWrapper{
-			System1Info: sysInfo,
-		}
-
-		// Convert system information to JSON
-		jsonData, err := json.Marshal(wrappedInfo)
-		//fmt.Println(jsonData)
-		if err != nil {
-			log.Fatal("Error marshalling system info to JSON:", err)
-		}
-
-		// Send the JSON data over WebSocket
-		err = conn.WriteMessage(websocket.TextMessage, jsonData)
-		if err != nil {
-			log.Println("Error sending message:", err)
+		if _, err := os.Stat(hostPath(dir + "/wireless")); err == nil {
+			continue
 		}
+		return Ethernet{Interface: e.Name()}, nil
+	}
+	return Ethernet{}, unavailable("no Ethernet interface")
+}
 
-		// Wait for 1 minute before sending the next update
-		time.Sleep(1 * time.Second)
+// humanBytes formats n the way df -h does, e.g. 512K, 9.8G, 265G.
+func humanBytes(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return strconv.FormatUint(n, 10)
+	}
+	value := float64(n)
+	unit := -1
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value < 10 {
+		return fmt.Sprintf("%.1f%c", math.Ceil(value*10)/10, units[unit])
 	}
+	return fmt.Sprintf("%.0f%c", math.Ceil(value), units[unit])
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReadMounts(t *testing.T) {
+	useFixtures(t, "laptop")
+	mounts, err := readMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "mounts", mounts)
+}
+
+func TestMountFilter(t *testing.T) {
+	useFixtures(t, "laptop")
+	mounts, err := readMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := defaultConfig().Disk
+	tests := []struct {
+		name   string
+		filter mountFilter
+		want   string // mount points kept, comma separated
+	}{
+		{"everything", mountFilter{}, "/,/proc,/sys,/run,/var/snap,/media/USB Stick"},
+		{"defaults", mountFilter{excludeTypes: defaults.ExcludeFSTypes, excludePaths: defaults.ExcludePaths}, "/,/proc,/sys,/var/snap,/media/USB Stick"},
+		{"include types", mountFilter{includeTypes: []string{"ext4"}}, "/,/var/snap"},
+		{"exclude wins", mountFilter{includeTypes: []string{"ext4", "vfat"}, excludeTypes: []string{"vfat"}}, "/,/var/snap"},
+		{"include paths", mountFilter{includePaths: []string{"/media/*"}}, "/media/USB Stick"},
+		{"exclude below", mountFilter{excludePaths: []string{"/var", "/proc", "/sys", "/run"}}, "/,/media/USB Stick"},
+	}
+	for _, tt := range tests {
+		var kept []string
+		for _, m := range mounts {
+			if tt.filter.match(m) {
+				kept = append(kept, m.Point)
+			}
+		}
+		if got := strings.Join(kept, ","); got != tt.want {
+			t.Errorf("%s: kept %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDebianCollectors(t *testing.T) {
+	useFixtures(t, "laptop")
+	ctx := context.Background()
+	checkGolden(t, "debian", map[string]collected{
+		"bluetooth":       newCollected(getblueusage(ctx)),
+		"ethernet":        newCollected(getEthernetInfo(ctx)),
+		"hardware_model":  newCollected(gethardwareModel(ctx)),
+		"hardware_vendor": newCollected(getVendorType(ctx)),
+		"os":              newCollected(getOSType(ctx)),
+	})
+}
+
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0"},
+		{1023, "1023"},
+		{512 << 10, "512K"},
+		{10522669875, "9.8G"},
+		{265 << 30, "265G"},
+	}
+	for _, tt := range tests {
+		if got := humanBytes(tt.n); got != tt.want {
+			t.Errorf("humanBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestDFPercent(t *testing.T) {
+	tests := []struct {
+		used, available uint64
+		want            float64
+	}{
+		{0, 0, 0},
+		{1, 99, 1},
+		{1, 199, 1}, // rounded up, like df
+		{50, 50, 50},
+	}
+	for _, tt := range tests {
+		if got := dfPercent(tt.used, tt.available); got != tt.want {
+			t.Errorf("dfPercent(%d, %d) = %g, want %g", tt.used, tt.available, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCollector("base", &diskIOCollector{}, 10*time.Second)
+}
+
+// DiskIO is the I/O load of each block device since the previous run.
+type DiskIO struct {
+	Devices []BlockDeviceIO `json:"devices"`
+}
+
+// BlockDeviceIO is what iostat -x shows for one device. Await is the
+// average time a request took, queueing included; QueueDepth is the average
+// number of requests in flight; UtilPercent the share of time the device was
+// busy.
+type BlockDeviceIO struct {
+	Device                 string  `json:"device"`
+	ReadsPerSecond         float64 `json:"reads_per_second"`
+	WritesPerSecond        float64 `json:"writes_per_second"`
+	ReadBytesPerSecond     float64 `json:"read_bytes_per_second"`
+	WriteBytesPerSecond    float64 `json:"write_bytes_per_second"`
+	ReadAwaitMilliseconds  float64 `json:"read_await_ms"`
+	WriteAwaitMilliseconds float64 `json:"write_await_ms"`
+	AwaitMilliseconds      float64 `json:"await_ms"`
+	QueueDepth             float64 `json:"queue_depth"`
+	UtilPercent            float64 `json:"util_percent"`
+}
+
+// diskStats is one line of /proc/diskstats.
+type diskStats struct {
+	reads, readSectors, readMs    uint64
+	writes, writeSectors, writeMs uint64
+	ioMs, weightedMs              uint64
+}
+
+// since reports whether every counter of s is at least where it was in
+// before, which is not the case when the device was replaced or a 32-bit
+// counter wrapped.
+func (s diskStats) since(before diskStats) bool {
+	return s.reads >= before.reads && s.readSectors >= before.readSectors && s.readMs >= before.readMs &&
+		s.writes >= before.writes && s.writeSectors >= before.writeSectors && s.writeMs >= before.writeMs &&
+		s.ioMs >= before.ioMs && s.weightedMs >= before.weightedMs
+}
+
+// diskStatsSample is /proc/diskstats at one moment.
+type diskStatsSample struct {
+	at      time.Time
+	devices map[string]diskStats
+}
+
+// sectorSize is the unit of the sector counters in /proc/diskstats,
+// whatever the device's real sector size.
+const sectorSize = 512
+
+// diskIOCollector reports per-device I/O rates from the difference between
+// two samples of /proc/diskstats: the previous run's and this one's.
+type diskIOCollector struct {
+	exclude    []string
+	partitions bool
+	prev       *diskStatsSample
+}
+
+func (c *diskIOCollector) Name() string {
+	return "disk_io"
+}
+
+func (c *diskIOCollector) configure(cfg *Config) error {
+	c.exclude = cfg.DiskIO.ExcludeDevices
+	c.partitions = cfg.DiskIO.Partitions
+	return nil
+}
+
+func (c *diskIOCollector) Collect(ctx context.Context) (interface{}, error) {
+	prev, cur, err := nextSample(ctx, &c.prev, c.sample)
+	if err != nil {
+		return DiskIO{}, err
+	}
+
+	io := DiskIO{Devices: []BlockDeviceIO{}}
+	elapsed := cur.at.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return io, nil
+	}
+	for name, after := range cur.devices {
+		before, ok := prev.devices[name]
+		if !ok || !after.since(before) {
+			continue // new since the previous sample, or its counters reset
+		}
+		io.Devices = append(io.Devices, deviceIO(name, before, after, elapsed))
+	}
+	sort.Slice(io.Devices, func(i, j int) bool { return io.Devices[i].Device < io.Devices[j].Device })
+	return io, nil
+}
+
+// deviceIO computes the rates of one device over elapsed seconds, the way
+// iostat -x does.
+func deviceIO(name string, before, after diskStats, elapsed float64) BlockDeviceIO {
+	reads := float64(after.reads - before.reads)
+	writes := float64(after.writes - before.writes)
+	readMs := float64(after.readMs - before.readMs)
+	writeMs := float64(after.writeMs - before.writeMs)
+	await := func(ms, n float64) float64 {
+		if n == 0 {
+			return 0
+		}
+		return round2(ms / n)
+	}
+	d := BlockDeviceIO{
+		Device:                 name,
+		ReadsPerSecond:         round2(reads / elapsed),
+		WritesPerSecond:        round2(writes / elapsed),
+		ReadBytesPerSecond:     round2(float64(after.readSectors-before.readSectors) * sectorSize / elapsed),
+		WriteBytesPerSecond:    round2(float64(after.writeSectors-before.writeSectors) * sectorSize / elapsed),
+		ReadAwaitMilliseconds:  await(readMs, reads),
+		WriteAwaitMilliseconds: await(writeMs, writes),
+		AwaitMilliseconds:      await(readMs+writeMs, reads+writes),
+		QueueDepth:             round2(float64(after.weightedMs-before.weightedMs) / (elapsed * 1000)),
+		UtilPercent:            round2(float64(after.ioMs-before.ioMs) / (elapsed * 1000) * 100),
+	}
+	if d.UtilPercent > 100 {
+		d.UtilPercent = 100 // io_ticks can run ahead of the wall clock by a tick
+	}
+	return d
+}
+
+// sample reads /proc/diskstats, keeping the devices that pass the filters:
+//
+//	8       0 sda 8710 2631 682310 4413 22044 31802 1298746 38551 0 24304 43941 ...
+//
+// The fields after the name are reads completed, merged, sectors read, ms
+// reading, the same four for writes, I/Os in flight, ms doing I/O and
+// weighted ms doing I/O; newer kernels append discard and flush counters.
+func (c *diskIOCollector) sample() (*diskStatsSample, error) {
+	// Whole disks are the entries of /sys/block; partitions are not. Without
+	// /sys (some containers) partitions cannot be told apart and are kept.
+	var disks map[string]bool
+	if !c.partitions {
+		if entries, err := os.ReadDir(hostPath("/sys/block")); err == nil {
+			disks = make(map[string]bool, len(entries))
+			for _, e := range entries {
+				// sysfs spells the slash in names like cciss/c0d0 as "!"
+				disks[strings.ReplaceAll(e.Name(), "!", "/")] = true
+			}
+		}
+	}
+
+	f, err := os.Open(hostPath("/proc/diskstats"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sample := &diskStatsSample{at: time.Now(), devices: make(map[string]diskStats)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+		name := fields[2]
+		if (disks != nil && !disks[name]) || matchDevice(c.exclude, name) {
+			continue
+		}
+		var values [11]uint64
+		for i := range values {
+			v, err := strconv.ParseUint(fields[3+i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: malformed line %q", f.Name(), scanner.Text())
+			}
+			values[i] = v
+		}
+		sample.devices[name] = diskStats{
+			reads:        values[0],
+			readSectors:  values[2],
+			readMs:       values[3],
+			writes:       values[4],
+			writeSectors: values[6],
+			writeMs:      values[7],
+			ioMs:         values[9],
+			weightedMs:   values[10],
+		}
+	}
+	return sample, scanner.Err()
+}
+
+func matchDevice(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
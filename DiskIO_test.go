@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDiskIOSample(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       diskIOCollector
+		devices string
+	}{
+		{"defaults", diskIOCollector{exclude: defaultConfig().DiskIO.ExcludeDevices}, "dm-0,nvme0n1,sda"},
+		{"nothing excluded", diskIOCollector{}, "dm-0,loop0,loop1,nvme0n1,sda"},
+		{"partitions", diskIOCollector{exclude: []string{"loop*", "dm-*"}, partitions: true}, "nvme0n1,nvme0n1p1,nvme0n1p2,sda,sda1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFixtures(t, "laptop")
+			sample, err := tt.c.sample()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for name := range sample.devices {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if got := strings.Join(names, ","); got != tt.devices {
+				t.Errorf("devices = %s, want %s", got, tt.devices)
+			}
+		})
+	}
+
+	useFixtures(t, "laptop")
+	sample, err := (&diskIOCollector{}).sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := diskStats{reads: 8710, readSectors: 682310, readMs: 4413, writes: 22044, writeSectors: 1298746, writeMs: 38551, ioMs: 24304, weightedMs: 43941}
+	if got := sample.devices["sda"]; got != want {
+		t.Errorf("sda = %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceIO(t *testing.T) {
+	before := diskStats{reads: 1000, readSectors: 8000, readMs: 500, writes: 2000, writeSectors: 16000, writeMs: 4000, ioMs: 10000, weightedMs: 20000}
+	after := diskStats{reads: 1100, readSectors: 10000, readMs: 600, writes: 2300, writeSectors: 22000, writeMs: 4900, ioMs: 10500, weightedMs: 21500}
+	got := deviceIO("sda", before, after, 2)
+	want := BlockDeviceIO{
+		Device:                 "sda",
+		ReadsPerSecond:         50,
+		WritesPerSecond:        150,
+		ReadBytesPerSecond:     512000,
+		WriteBytesPerSecond:    1536000,
+		ReadAwaitMilliseconds:  1,
+		WriteAwaitMilliseconds: 3,
+		AwaitMilliseconds:      2.5,
+		QueueDepth:             0.75,
+		UtilPercent:            25,
+	}
+	if got != want {
+		t.Errorf("deviceIO = %+v, want %+v", got, want)
+	}
+
+	// idle device: no division by zero, util capped at 100
+	after = before
+	after.ioMs += 2100
+	got = deviceIO("sda", before, after, 2)
+	if got.AwaitMilliseconds != 0 || got.UtilPercent != 100 {
+		t.Errorf("idle deviceIO = %+v", got)
+	}
+}
+
+func TestDiskStatsSince(t *testing.T) {
+	before := diskStats{reads: 10, writes: 10, ioMs: 10}
+	if !(diskStats{reads: 10, writes: 11, ioMs: 10}).since(before) {
+		t.Error("counters moving forward not seen as since")
+	}
+	if (diskStats{reads: 10, writes: 11, ioMs: 9}).since(before) {
+		t.Error("a counter going backwards seen as since")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxQueuedEvents bounds the events held between two snapshots. Beyond it
+// the oldest are dropped and an events_dropped event says how many.
+const maxQueuedEvents = 1000
+
+// Event is something that happened between two snapshots, as opposed to the
+// state collectors report. Each snapshot carries the events raised since
+// the previous one, oldest first.
+type Event struct {
+	Type   string      `json:"type"`
+	Time   string      `json:"time"`
+	Source string      `json:"source"` // the collector that raised it
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// eventQueue collects events from the collectors until the next snapshot.
+type eventQueue struct {
+	mu      sync.Mutex
+	events  []Event
+	dropped int
+}
+
+var events = &eventQueue{}
+
+// emit queues an event of type typ raised by source, which happened at at.
+func (q *eventQueue) emit(source, typ string, at time.Time, data interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.events) >= maxQueuedEvents {
+		q.events = q.events[1:]
+		q.dropped++
+	}
+	q.events = append(q.events, Event{Type: typ, Time: at.Format(time.RFC3339), Source: source, Data: data})
+}
+
+// drain returns the queued events and empties the queue.
+func (q *eventQueue) drain() []Event {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	drained := q.events
+	if q.dropped > 0 {
+		drained = append(drained, Event{
+			Type:   "events_dropped",
+			Time:   time.Now().Format(time.RFC3339),
+			Source: "agent",
+			Data:   map[string]int{"count": q.dropped},
+		})
+	}
+	q.events, q.dropped = nil, 0
+	if drained == nil {
+		drained = []Event{}
+	}
+	return drained
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Firewall is the state of the host firewall. Whatever manages it, the rules
+// end up in the kernel's nftables or iptables ruleset, which is what
+// Policies, RuleCount and RulesetHash describe.
+type Firewall struct {
+	// Backend is what manages the rules: firewalld or ufw when either is
+	// running, otherwise nftables or iptables.
+	Backend     string        `json:"backend"`
+	Active      bool          `json:"active"`
+	Policies    []ChainPolicy `json:"policies"`
+	RuleCount   int           `json:"rule_count"`
+	RulesetHash string        `json:"ruleset_hash"`
+
+	// BaselineHash is the hash of the approved ruleset, and Drifted whether
+	// the current one differs from it. Both are left out when no baseline
+	// has been blessed.
+	BaselineHash string `json:"baseline_hash,omitempty"`
+	Drifted      *bool  `json:"drifted,omitempty"`
+}
+
+// ChainPolicy is the default policy of a base chain, the verdict for packets
+// no rule in the chain decides.
+type ChainPolicy struct {
+	Family string `json:"family"` // ip, ip6, inet, ...
+	Table  string `json:"table"`
+	Chain  string `json:"chain"`
+	Hook   string `json:"hook"`
+	Policy string `json:"policy"`
+}
+
+// FirewallRule is one rule, identified by where it sits and what it says:
+// for nftables the rule's JSON expression, for iptables the iptables-save
+// line without "-A <chain>".
+type FirewallRule struct {
+	Family string `json:"family"`
+	Table  string `json:"table"`
+	Chain  string `json:"chain"`
+	Rule   string `json:"rule"`
+}
+
+func (f Firewall) display() map[string]string {
+	if f.Active {
+		return map[string]string{"Firewallstatus": "active"}
+	}
+	return map[string]string{"Firewallstatus": "inactive"}
+}
+
+// ruleset is the kernel's packet filter configuration.
+type ruleset struct {
+	Backend   string // nftables or iptables
+	Policies  []ChainPolicy
+	RuleCount int
+	Rules     []FirewallRule
+	Hash      string
+}
+
+// filters reports whether the ruleset does anything: a rule, or a chain that
+// does not accept by default.
+func (r ruleset) filters() bool {
+	if r.RuleCount > 0 {
+		return true
+	}
+	for _, p := range r.Policies {
+		if p.Policy != "accept" {
+			return true
+		}
+	}
+	return false
+}
+
+// readFirewall reads the ruleset and works out what manages it.
+func readFirewall(ctx context.Context) (Firewall, ruleset, error) {
+	rs, err := readRuleset(ctx)
+	if err != nil {
+		return Firewall{}, ruleset{}, err
+	}
+	fw := Firewall{
+		Backend:     rs.Backend,
+		Active:      rs.filters(),
+		Policies:    append([]ChainPolicy{}, rs.Policies...),
+		RuleCount:   rs.RuleCount,
+		RulesetHash: rs.Hash,
+	}
+	switch {
+	case firewalldRunning(ctx):
+		fw.Backend, fw.Active = "firewalld", true
+	case ufwEnabled():
+		fw.Backend, fw.Active = "ufw", true
+	}
+	return fw, rs, nil
+}
+
+// readRuleset reads the ruleset with nft, falling back to iptables-save
+// where nft is not installed or shows nothing (iptables-legacy hosts).
+// Both need root.
+func readRuleset(ctx context.Context) (ruleset, error) {
+	out, err := runner.Output(ctx, "nft", "-j", "-s", "list", "ruleset")
+	nftAnswered := err == nil
+	var nft ruleset
+	if nftAnswered {
+		if nft, err = parseNftRuleset(out); err != nil {
+			return ruleset{}, err
+		}
+		if len(nft.Policies) > 0 || nft.RuleCount > 0 {
+			return nft, nil
+		}
+	} else if err = commandError("nft", err); !errors.Is(err, errUnavailable) {
+		return ruleset{}, err
+	}
+
+	ipt, err := readIptables(ctx)
+	if nftAnswered && (err != nil || (len(ipt.Policies) == 0 && ipt.RuleCount == 0)) {
+		return nft, nil
+	}
+	return ipt, err
+}
+
+// readIptables reads the IPv4 and, if ip6tables is installed, IPv6 rules.
+func readIptables(ctx context.Context) (ruleset, error) {
+	v4, err := runner.Output(ctx, "iptables-save")
+	if err != nil {
+		return ruleset{}, commandError("iptables-save", err)
+	}
+	v6, err := runner.Output(ctx, "ip6tables-save")
+	if err != nil {
+		if err = commandError("ip6tables-save", err); !errors.Is(err, errUnavailable) {
+			return ruleset{}, err
+		}
+		v6 = nil
+	}
+	return parseIptablesSave(v4, v6), nil
+}
+
+// parseNftRuleset parses the output of "nft -j -s list ruleset". Stateless
+// (-s) output leaves out counters, so the hash only changes with the rules;
+// handles, which the kernel renumbers on every reload, are dropped from it
+// as well. So are the elements of sets and maps, which fail2ban, Docker and
+// the like update at runtime without touching a rule; the sets' definitions
+// still count.
+func parseNftRuleset(data []byte) (ruleset, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc struct {
+		Nftables []map[string]interface{} `json:"nftables"`
+	}
+	if err := dec.Decode(&doc); err != nil {
+		return ruleset{}, fmt.Errorf("nft: %w", err)
+	}
+
+	rs := ruleset{Backend: "nftables"}
+	var objects []interface{}
+	for _, obj := range doc.Nftables {
+		if _, ok := obj["metainfo"]; ok {
+			continue
+		}
+		if _, ok := obj["element"]; ok {
+			continue
+		}
+		for _, kind := range []string{"set", "map"} {
+			if set, ok := obj[kind].(map[string]interface{}); ok {
+				delete(set, "elem")
+			}
+		}
+		if chain, ok := obj["chain"].(map[string]interface{}); ok {
+			if hook, _ := chain["hook"].(string); hook != "" {
+				policy, _ := chain["policy"].(string)
+				if policy == "" {
+					policy = "accept"
+				}
+				family, _ := chain["family"].(string)
+				table, _ := chain["table"].(string)
+				name, _ := chain["name"].(string)
+				rs.Policies = append(rs.Policies, ChainPolicy{Family: family, Table: table, Chain: name, Hook: hook, Policy: policy})
+			}
+		}
+		obj = dropKey(obj, "handle").(map[string]interface{})
+		if rule, ok := obj["rule"].(map[string]interface{}); ok {
+			rs.RuleCount++
+			rs.Rules = append(rs.Rules, nftRule(rule))
+		}
+		objects = append(objects, obj)
+	}
+	sortPolicies(rs.Policies)
+
+	// Maps marshal with sorted keys, which makes the encoding canonical.
+	canonical, err := json.Marshal(objects)
+	if err != nil {
+		return ruleset{}, err
+	}
+	sum := sha256.Sum256(canonical)
+	rs.Hash = "sha256:" + hex.EncodeToString(sum[:])
+	return rs, nil
+}
+
+// nftRule identifies an nft rule by its chain and the JSON of everything
+// else it holds, its expression and comment.
+func nftRule(rule map[string]interface{}) FirewallRule {
+	r := FirewallRule{}
+	r.Family, _ = rule["family"].(string)
+	r.Table, _ = rule["table"].(string)
+	r.Chain, _ = rule["chain"].(string)
+	rest := make(map[string]interface{}, len(rule))
+	for k, v := range rule {
+		if k != "family" && k != "table" && k != "chain" {
+			rest[k] = v
+		}
+	}
+	text, _ := json.Marshal(rest)
+	r.Rule = string(text)
+	return r
+}
+
+// dropKey removes key from every object nested in v.
+func dropKey(v interface{}, key string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, key)
+		for k, e := range v {
+			v[k] = dropKey(e, key)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = dropKey(e, key)
+		}
+	}
+	return v
+}
+
+// iptablesCounterRe matches the packet and byte counters after a chain's
+// policy in iptables-save output.
+var iptablesCounterRe = regexp.MustCompile(`\s*\[\d+:\d+\]$`)
+
+// parseIptablesSave parses iptables-save and ip6tables-save output:
+//
+//	*filter
+//	:INPUT DROP [0:0]
+//	:ufw-user-input - [0:0]
+//	-A INPUT -j ufw-user-input
+//	COMMIT
+//
+// Built-in chains have a policy; user-defined ones have "-". The hash skips
+// comments, which carry a timestamp, and counters.
+func parseIptablesSave(v4, v6 []byte) ruleset {
+	rs := ruleset{Backend: "iptables"}
+	h := sha256.New()
+	for _, family := range []struct {
+		name string
+		data []byte
+	}{{"ip", v4}, {"ip6", v6}} {
+		fmt.Fprintf(h, "# %s\n", family.name)
+		table := ""
+		scanner := bufio.NewScanner(bytes.NewReader(family.data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			line = iptablesCounterRe.ReplaceAllString(line, "")
+			fmt.Fprintln(h, line)
+
+			switch {
+			case strings.HasPrefix(line, "*"):
+				table = line[1:]
+			case strings.HasPrefix(line, ":"):
+				fields := strings.Fields(line[1:])
+				if len(fields) == 2 && fields[1] != "-" {
+					rs.Policies = append(rs.Policies, ChainPolicy{
+						Family: family.name,
+						Table:  table,
+						Chain:  fields[0],
+						Hook:   strings.ToLower(fields[0]),
+						Policy: strings.ToLower(fields[1]),
+					})
+				}
+			case strings.HasPrefix(line, "-A "):
+				chain, rule, _ := strings.Cut(strings.TrimPrefix(line, "-A "), " ")
+				rs.RuleCount++
+				rs.Rules = append(rs.Rules, FirewallRule{Family: family.name, Table: table, Chain: chain, Rule: rule})
+			}
+		}
+	}
+	sortPolicies(rs.Policies)
+	rs.Hash = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	return rs
+}
+
+func sortPolicies(policies []ChainPolicy) {
+	sort.Slice(policies, func(i, j int) bool {
+		a, b := policies[i], policies[j]
+		if a.Family != b.Family {
+			return a.Family < b.Family
+		}
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.Chain < b.Chain
+	})
+}
+
+// firewalldRunning asks firewalld whether it is running, which works
+// without root. Not installed, not running and not answering are all no.
+func firewalldRunning(ctx context.Context) bool {
+	out, err := runner.Output(ctx, "firewall-cmd", "--state")
+	return err == nil && strings.TrimSpace(string(out)) == "running"
+}
+
+// ufwEnabled reads ufw's own configuration, which is world-readable where
+// "ufw status" is not.
+func ufwEnabled() bool {
+	data, err := readHostFile("/etc/ufw/ufw.conf")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(data, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok && key == "ENABLED" {
+			return strings.EqualFold(strings.Trim(value, `"'`), "yes")
+		}
+	}
+	return false
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// firewallBaseline is the approved ruleset, as written by bless-firewall.
+type firewallBaseline struct {
+	BlessedAt   string         `json:"blessed_at"`
+	Backend     string         `json:"backend"`
+	RulesetHash string         `json:"ruleset_hash"`
+	Policies    []ChainPolicy  `json:"policies"`
+	Rules       []FirewallRule `json:"rules"`
+}
+
+// FirewallDrift is the data of a firewall_drift event: how the ruleset
+// differs from the baseline. A ruleset whose rules were only reordered has
+// a different hash but no added or removed rules.
+type FirewallDrift struct {
+	BaselineHash    string         `json:"baseline_hash"`
+	RulesetHash     string         `json:"ruleset_hash"`
+	AddedRules      []FirewallRule `json:"added_rules"`
+	RemovedRules    []FirewallRule `json:"removed_rules"`
+	ChangedPolicies []PolicyChange `json:"changed_policies"`
+}
+
+// PolicyChange is a base chain whose default policy differs from the
+// baseline. Baseline or Current is empty for a chain that was added or
+// removed.
+type PolicyChange struct {
+	Family   string `json:"family"`
+	Table    string `json:"table"`
+	Chain    string `json:"chain"`
+	Baseline string `json:"baseline"`
+	Current  string `json:"current"`
+}
+
+// firewallCollector reports the firewall and, when a baseline has been
+// blessed, compares the ruleset with it. Each drift is reported once: the
+// hashes last reported are saved in the state dir, and the event is raised
+// again only when the ruleset or the baseline changes.
+type firewallCollector struct {
+	baselinePath string
+	loaded       bool
+	state        firewallState
+	baselineErr  string // last problem reading the baseline, logged once
+}
+
+type firewallState struct {
+	// ReportedDrift is "<baseline hash> <ruleset hash>" of the last
+	// firewall_drift event, empty while the ruleset matches.
+	ReportedDrift string `json:"reported_drift"`
+}
+
+const firewallStateFile = "firewall.json"
+
+func (c *firewallCollector) Name() string {
+	return "firewall"
+}
+
+func (c *firewallCollector) configure(cfg *Config) error {
+	c.baselinePath = cfg.Firewall.Baseline
+	return nil
+}
+
+func (c *firewallCollector) Collect(ctx context.Context) (interface{}, error) {
+	fw, rs, err := readFirewall(ctx)
+	if err != nil {
+		return Firewall{}, err
+	}
+	if c.baselinePath == "" {
+		return fw, nil
+	}
+	if !c.loaded {
+		if err := loadState(firewallStateFile, &c.state); err != nil {
+			log.Println("Ignoring unreadable firewall state:", err)
+		}
+		c.loaded = true
+	}
+
+	baseline, err := loadFirewallBaseline(c.baselinePath)
+	if err != nil {
+		if msg := err.Error(); msg != c.baselineErr && !errors.Is(err, os.ErrNotExist) {
+			log.Println("Not checking the firewall for drift:", err)
+			c.baselineErr = msg
+		}
+		return fw, nil
+	}
+	c.baselineErr = ""
+
+	drifted := rs.Hash != baseline.RulesetHash
+	fw.BaselineHash = baseline.RulesetHash
+	fw.Drifted = &drifted
+
+	reported := ""
+	if drifted {
+		reported = baseline.RulesetHash + " " + rs.Hash
+	}
+	if reported == c.state.ReportedDrift {
+		return fw, nil
+	}
+	if drifted {
+		events.emit(c.Name(), "firewall_drift", time.Now(), diffRuleset(baseline, rs))
+	}
+	c.state.ReportedDrift = reported
+	saveCollectorState(firewallStateFile, c.state)
+	return fw, nil
+}
+
+func loadFirewallBaseline(path string) (*firewallBaseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var baseline firewallBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if baseline.RulesetHash == "" {
+		return nil, fmt.Errorf("%s: no ruleset_hash", path)
+	}
+	return &baseline, nil
+}
+
+// diffRuleset compares rules as a multiset, so a rule that appears twice in
+// the ruleset and once in the baseline is reported as added once.
+func diffRuleset(baseline *firewallBaseline, rs ruleset) FirewallDrift {
+	drift := FirewallDrift{
+		BaselineHash:    baseline.RulesetHash,
+		RulesetHash:     rs.Hash,
+		AddedRules:      []FirewallRule{},
+		RemovedRules:    []FirewallRule{},
+		ChangedPolicies: []PolicyChange{},
+	}
+
+	counts := make(map[FirewallRule]int)
+	for _, r := range baseline.Rules {
+		counts[r]++
+	}
+	for _, r := range rs.Rules {
+		if counts[r] > 0 {
+			counts[r]--
+		} else {
+			drift.AddedRules = append(drift.AddedRules, r)
+		}
+	}
+	for _, r := range baseline.Rules {
+		if counts[r] > 0 {
+			counts[r]--
+			drift.RemovedRules = append(drift.RemovedRules, r)
+		}
+	}
+
+	type chainKey struct{ family, table, chain string }
+	policies := make(map[chainKey]*PolicyChange)
+	for _, p := range baseline.Policies {
+		policies[chainKey{p.Family, p.Table, p.Chain}] = &PolicyChange{Family: p.Family, Table: p.Table, Chain: p.Chain, Baseline: p.Policy}
+	}
+	for _, p := range rs.Policies {
+		k := chainKey{p.Family, p.Table, p.Chain}
+		if policies[k] == nil {
+			policies[k] = &PolicyChange{Family: p.Family, Table: p.Table, Chain: p.Chain}
+		}
+		policies[k].Current = p.Policy
+	}
+	for _, change := range policies {
+		if change.Baseline != change.Current {
+			drift.ChangedPolicies = append(drift.ChangedPolicies, *change)
+		}
+	}
+	sort.Slice(drift.ChangedPolicies, func(i, j int) bool {
+		a, b := drift.ChangedPolicies[i], drift.ChangedPolicies[j]
+		if a.Family != b.Family {
+			return a.Family < b.Family
+		}
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		return a.Chain < b.Chain
+	})
+	return drift
+}
+
+// runBlessFirewall implements the bless-firewall command: it reads the
+// current ruleset, shows how it differs from the old baseline, and makes it
+// the new one. Reading the ruleset needs root, or the helper.
+func runBlessFirewall(args []string) int {
+	fs := flag.NewFlagSet("bless-firewall", flag.ExitOnError)
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 1
+	}
+	if cfg.Firewall.Baseline == "" {
+		fmt.Fprintln(os.Stderr, "firewall.baseline is not set")
+		return 1
+	}
+	setHostRoot(cfg.HostRoot)
+	if cfg.Helper.Socket != "" {
+		helper = &helperClient{socket: cfg.Helper.Socket}
+		runner = helperRunner{helper: helper, local: execRunner{}}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Collect)
+	defer cancel()
+	fw, rs, err := readFirewall(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading the firewall:", err)
+		return 1
+	}
+
+	if old, err := loadFirewallBaseline(cfg.Firewall.Baseline); err == nil {
+		if old.RulesetHash == rs.Hash {
+			fmt.Println("The ruleset already matches the baseline in", cfg.Firewall.Baseline)
+			return 0
+		}
+		drift := diffRuleset(old, rs)
+		for _, r := range drift.RemovedRules {
+			fmt.Printf("- %s %s %s: %s\n", r.Family, r.Table, r.Chain, r.Rule)
+		}
+		for _, r := range drift.AddedRules {
+			fmt.Printf("+ %s %s %s: %s\n", r.Family, r.Table, r.Chain, r.Rule)
+		}
+		for _, p := range drift.ChangedPolicies {
+			fmt.Printf("~ %s %s %s policy: %q -> %q\n", p.Family, p.Table, p.Chain, p.Baseline, p.Current)
+		}
+	}
+
+	baseline := firewallBaseline{
+		BlessedAt:   time.Now().Format(time.RFC3339),
+		Backend:     fw.Backend,
+		RulesetHash: rs.Hash,
+		Policies:    append([]ChainPolicy{}, rs.Policies...),
+		Rules:       append([]FirewallRule{}, rs.Rules...),
+	}
+	if err := writeFirewallBaseline(cfg.Firewall.Baseline, baseline); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing the baseline:", err)
+		return 1
+	}
+	fmt.Printf("Blessed %s (%d rules) as the firewall baseline in %s\n", rs.Hash, rs.RuleCount, cfg.Firewall.Baseline)
+	return 0
+}
+
+// writeFirewallBaseline replaces the baseline atomically, so the agent never
+// reads a half-written one.
+func writeFirewallBaseline(path string, baseline firewallBaseline) error {
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func readCommandFixture(t *testing.T, scenario, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "commands", scenario, name+".out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseNftRuleset(t *testing.T) {
+	rs, err := parseNftRuleset(readCommandFixture(t, "laptop", "nft_-j_-s_list_ruleset"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "firewall_nft", rs)
+
+	if _, err := parseNftRuleset([]byte(`{"nftables": [`)); err == nil {
+		t.Error("truncated nft output parsed")
+	}
+}
+
+func TestParseIptablesSave(t *testing.T) {
+	v4 := readCommandFixture(t, "server", "iptables-save")
+	v6 := readCommandFixture(t, "server", "ip6tables-save")
+	checkGolden(t, "firewall_iptables", parseIptablesSave(v4, v6))
+
+	// without ip6tables the IPv6 rules are missing, and the hash differs
+	if rs := parseIptablesSave(v4, nil); rs.RuleCount != 8 || rs.Hash == parseIptablesSave(v4, v6).Hash {
+		t.Errorf("IPv4 only: %d rules, hash %s", rs.RuleCount, rs.Hash)
+	}
+}
+
+// TestNftHashAfterReload checks that a ruleset reloaded unchanged, which the
+// kernel gives new handles, keeps its hash, and that a changed one does not.
+func TestNftHashAfterReload(t *testing.T) {
+	data := readCommandFixture(t, "laptop", "nft_-j_-s_list_ruleset")
+	before, err := parseNftRuleset(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handleRe := regexp.MustCompile(`"handle": (\d+)`)
+	reloaded := handleRe.ReplaceAllFunc(data, func(m []byte) []byte {
+		n, _ := strconv.Atoi(string(handleRe.FindSubmatch(m)[1]))
+		return []byte(`"handle": ` + strconv.Itoa(n+1000))
+	})
+	if string(reloaded) == string(data) {
+		t.Fatal("no handles renumbered")
+	}
+	after, err := parseNftRuleset(reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Hash != before.Hash {
+		t.Errorf("new handles changed the hash: %s, was %s", after.Hash, before.Hash)
+	}
+
+	changed, err := parseNftRuleset([]byte(strings.Replace(string(data), `"policy": "drop"`, `"policy": "accept"`, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Hash == before.Hash {
+		t.Error("a changed policy kept the hash")
+	}
+}
+
+// TestNftHashIgnoresSetElements checks that fail2ban adding a ban to its set
+// leaves the hash alone, so it raises no firewall_drift, while a change to
+// the set itself does not.
+func TestNftHashIgnoresSetElements(t *testing.T) {
+	data := string(readCommandFixture(t, "laptop", "nft_-j_-s_list_ruleset"))
+	before, err := parseNftRuleset([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	banned := strings.Replace(data, `"elem": ["198.51.100.23", "203.0.113.7"]`, `"elem": ["192.0.2.99"]`, 1)
+	added := `{"element": {"family": "ip", "table": "filter", "name": "f2b-sshd", "elem": ["192.0.2.100"]}}]}`
+	banned = strings.TrimSuffix(strings.TrimSpace(banned), "]}") + ", " + added
+	if strings.Count(banned, "192.0.2.") != 2 {
+		t.Fatal("set elements not changed")
+	}
+	after, err := parseNftRuleset([]byte(banned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Hash != before.Hash {
+		t.Errorf("new set elements changed the hash: %s, was %s", after.Hash, before.Hash)
+	}
+
+	changed, err := parseNftRuleset([]byte(strings.Replace(data, `"type": "ipv4_addr"`, `"type": "ipv6_addr"`, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.Hash == before.Hash {
+		t.Error("a changed set kept the hash")
+	}
+}
+
+// TestIptablesHashAfterReload does the same for iptables-save, whose
+// counters move with traffic and whose comments carry the time of the dump.
+func TestIptablesHashAfterReload(t *testing.T) {
+	v4 := string(readCommandFixture(t, "server", "iptables-save"))
+	v6 := readCommandFixture(t, "server", "ip6tables-save")
+	before := parseIptablesSave([]byte(v4), v6)
+
+	reloaded := regexp.MustCompile(`\[\d+:\d+\]`).ReplaceAllString(v4, "[12:3456]")
+	reloaded = strings.ReplaceAll(reloaded, "Sun Oct 18 05:12:44 2026", "Mon Oct 19 09:30:01 2026")
+	if reloaded == v4 {
+		t.Fatal("no counters or comments changed")
+	}
+	if after := parseIptablesSave([]byte(reloaded), v6); after.Hash != before.Hash {
+		t.Errorf("new counters changed the hash: %s, was %s", after.Hash, before.Hash)
+	}
+
+	changed := strings.Replace(v4, "--dport 443", "--dport 8443", 1)
+	if parseIptablesSave([]byte(changed), v6).Hash == before.Hash {
+		t.Error("a changed rule kept the hash")
+	}
+}
+
+func TestReadFirewall(t *testing.T) {
+	tests := []struct {
+		scenario string
+		status   string
+		backend  string
+		ruleset  string // backend of the ruleset read
+	}{
+		// ufw.conf in testdata/host says enabled
+		{"laptop", statusOK, "ufw", "nftables"},
+		{"server", statusOK, "firewalld", "iptables"},
+		{"minimal", statusPermissionDenied, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scenario, func(t *testing.T) {
+			useFixtures(t, tt.scenario)
+			fw, rs, err := readFirewall(context.Background())
+			if got := statusOf(err); got != tt.status {
+				t.Fatalf("status %s (%v), want %s", got, err, tt.status)
+			}
+			if fw.Backend != tt.backend || rs.Backend != tt.ruleset {
+				t.Errorf("backend %s, ruleset %s, want %s, %s", fw.Backend, rs.Backend, tt.backend, tt.ruleset)
+			}
+			if err == nil && (!fw.Active || fw.RulesetHash != rs.Hash) {
+				t.Errorf("firewall = %+v", fw)
+			}
+		})
+	}
+}
+
+func TestDiffRuleset(t *testing.T) {
+	v4 := string(readCommandFixture(t, "server", "iptables-save"))
+	v6 := readCommandFixture(t, "server", "ip6tables-save")
+	base := parseIptablesSave([]byte(v4), v6)
+	baseline := &firewallBaseline{RulesetHash: base.Hash, Policies: base.Policies, Rules: base.Rules}
+
+	if drift := diffRuleset(baseline, base); len(drift.AddedRules)+len(drift.RemovedRules)+len(drift.ChangedPolicies) != 0 {
+		t.Errorf("unchanged ruleset drifted: %+v", drift)
+	}
+
+	changed := strings.Replace(v4, "--dport 443", "--dport 8443", 1)
+	changed = strings.Replace(changed, ":FORWARD DROP", ":FORWARD ACCEPT", 1)
+	drift := diffRuleset(baseline, parseIptablesSave([]byte(changed), v6))
+	want := FirewallRule{Family: "ip", Table: "filter", Chain: "INPUT", Rule: "-p tcp -m tcp --dport 443 -j ACCEPT"}
+	if len(drift.RemovedRules) != 1 || drift.RemovedRules[0] != want {
+		t.Errorf("removed %+v, want %+v", drift.RemovedRules, want)
+	}
+	want.Rule = "-p tcp -m tcp --dport 8443 -j ACCEPT"
+	if len(drift.AddedRules) != 1 || drift.AddedRules[0] != want {
+		t.Errorf("added %+v, want %+v", drift.AddedRules, want)
+	}
+	policy := PolicyChange{Family: "ip", Table: "filter", Chain: "FORWARD", Baseline: "drop", Current: "accept"}
+	if len(drift.ChangedPolicies) != 1 || drift.ChangedPolicies[0] != policy {
+		t.Errorf("changed policies %+v, want %+v", drift.ChangedPolicies, policy)
+	}
+
+	// reordering rules changes the hash but adds or removes nothing
+	reordered := strings.Replace(v4, "-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT\n-A INPUT -p tcp -m tcp --dport 443 -j ACCEPT",
+		"-A INPUT -p tcp -m tcp --dport 443 -j ACCEPT\n-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT", 1)
+	rs := parseIptablesSave([]byte(reordered), v6)
+	if drift := diffRuleset(baseline, rs); rs.Hash == base.Hash || len(drift.AddedRules)+len(drift.RemovedRules) != 0 {
+		t.Errorf("reordered: hash %s, drift %+v", rs.Hash, drift)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The helper is a small process run as root next to the unprivileged agent.
+// It answers a fixed set of queries over a Unix socket, so the agent, and
+// with it the WebSocket code, never needs root itself:
+//
+//	shm-agent helper -config /etc/shm-agent/config.yaml
+//
+// Each connection carries one JSON helperRequest and gets one helperResponse
+// back. Callers are identified by the kernel (SO_PEERCRED), not by anything
+// they send, and only helper.allow_users may ask.
+
+// helper is the client for the helper configured with helper.socket, or nil
+// when the agent does everything itself.
+var helper *helperClient
+
+// Queries the helper answers.
+const (
+	helperRun          = "run"           // run one allowlisted command line
+	helperSocketOwners = "socket_owners" // socketOwners across every user's processes
+)
+
+type helperRequest struct {
+	Query   string   `json:"query"`
+	Command []string `json:"command,omitempty"`
+}
+
+type helperResponse struct {
+	Error      string           `json:"error,omitempty"`
+	Denied     bool             `json:"denied,omitempty"`
+	NotFound   bool             `json:"not_found,omitempty"`
+	ExitStatus string           `json:"exit_status,omitempty"` // e.g. "exit status 1", empty on success
+	Stdout     []byte           `json:"stdout,omitempty"`
+	Stderr     []byte           `json:"stderr,omitempty"`
+	Owners     map[uint64][]int `json:"owners,omitempty"`
+}
+
+// helperCommands are the command lines collectors may have the helper run,
+// word for word. Scans of the configured scanner targets are added to them.
+var helperCommands = [][]string{
+	{"nft", "-j", "-s", "list", "ruleset"},
+	{"iptables-save"},
+	{"ip6tables-save"},
+}
+
+// helperCommandNames are the commands helperRunner sends to the helper.
+var helperCommandNames = map[string]bool{
+	"nft":            true,
+	"iptables-save":  true,
+	"ip6tables-save": true,
+	"nmap":           true,
+}
+
+// maxHelperRequest bounds what the helper reads from a caller.
+const maxHelperRequest = 64 << 10
+
+// maxHelperConns bounds the connections the helper serves at once; further
+// callers wait in the listen backlog.
+const maxHelperConns = 16
+
+// helperIOTimeout bounds reading a request and writing its answer, so an
+// allowed caller that stops talking cannot hold a connection forever.
+const helperIOTimeout = 10 * time.Second
+
+// helperSocketOwnersTimeout bounds the socket_owners query, which has no
+// collector context to inherit a deadline from.
+const helperSocketOwnersTimeout = 10 * time.Second
+
+// helperClient asks the helper listening on socket.
+type helperClient struct {
+	socket string
+}
+
+// query sends req and waits for the answer until ctx is done.
+func (h *helperClient) query(ctx context.Context, req helperRequest) (*helperResponse, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", h.socket)
+	if err != nil {
+		return nil, unavailable("privileged helper is not reachable: %v", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	// The helper refuses callers without reading their request, so the
+	// write can fail while the refusal is waiting to be read.
+	writeErr := json.NewEncoder(conn).Encode(req)
+	var resp helperResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if writeErr != nil {
+			err = writeErr
+		}
+		return nil, fmt.Errorf("helper: %w", err)
+	}
+	switch {
+	case resp.Denied:
+		return nil, permissionError{msg: "helper: " + resp.Error}
+	case resp.Error != "":
+		return nil, errors.New("helper: " + resp.Error)
+	}
+	return &resp, nil
+}
+
+// run has the helper run a command line and returns its stdout, failing the
+// same way execRunner does.
+func (h *helperClient) run(ctx context.Context, argv []string) ([]byte, error) {
+	resp, err := h.query(ctx, helperRequest{Query: helperRun, Command: argv})
+	if err != nil {
+		return nil, err
+	}
+	if resp.NotFound {
+		return nil, &exec.Error{Name: argv[0], Err: exec.ErrNotFound}
+	}
+	if resp.ExitStatus != "" {
+		return resp.Stdout, &exitError{msg: resp.ExitStatus, stderr: resp.Stderr}
+	}
+	return resp.Stdout, nil
+}
+
+// socketOwners is socketOwners as seen by root.
+func (h *helperClient) socketOwners() (map[uint64][]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), helperSocketOwnersTimeout)
+	defer cancel()
+	resp, err := h.query(ctx, helperRequest{Query: helperSocketOwners})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Owners, nil
+}
+
+// helperRunner runs the commands that need root through the helper and
+// everything else with local.
+type helperRunner struct {
+	helper *helperClient
+	local  commandRunner
+}
+
+func (r helperRunner) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if helperCommandNames[name] {
+		return r.helper.run(ctx, append([]string{name}, args...))
+	}
+	return r.local.Output(ctx, name, args...)
+}
+
+// helperServer answers queries from the allowed users.
+type helperServer struct {
+	cfg         *Config
+	allowedUIDs map[uint32]bool
+	allowed     map[string]bool // command lines, words joined by NUL
+}
+
+// runHelper implements the helper command.
+func runHelper(args []string) int {
+	fs := flag.NewFlagSet("helper", flag.ExitOnError)
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 1
+	}
+	if cfg.Helper.Socket == "" || len(cfg.Helper.AllowUsers) == 0 {
+		fmt.Fprintln(os.Stderr, "helper.socket and helper.allow_users must be set to run the helper")
+		return 1
+	}
+	if os.Geteuid() != 0 {
+		log.Println("The helper is not running as root; its answers are no better than the agent's")
+	}
+	setHostRoot(cfg.HostRoot)
+
+	s := &helperServer{cfg: cfg, allowedUIDs: make(map[uint32]bool), allowed: make(map[string]bool)}
+	for _, name := range cfg.Helper.AllowUsers {
+		uid, err := lookupUID(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "helper.allow_users:", err)
+			return 1
+		}
+		s.allowedUIDs[uid] = true
+	}
+	for _, argv := range helperCommands {
+		s.allowed[strings.Join(argv, "\x00")] = true
+	}
+	if cfg.Scanner.Enabled {
+		for _, target := range cfg.Scanner.Targets {
+			s.allowed[strings.Join(scanCommand(cfg.Scanner, target), "\x00")] = true
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := s.serve(ctx); err != nil {
+		log.Println("Helper:", err)
+		return 1
+	}
+	return 0
+}
+
+// lookupUID resolves a user name, or a numeric uid, to a uid.
+func lookupUID(name string) (uint32, error) {
+	if uid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(uid), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(uid), err
+}
+
+// serve listens on the configured socket until ctx is done. The socket is
+// world-writable: who may ask is decided by peer credentials, which the
+// caller cannot forge, rather than by file permissions.
+func (s *helperServer) serve(ctx context.Context) error {
+	path := s.cfg.Helper.Socket
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	if err := os.Chmod(path, 0666); err != nil {
+		return err
+	}
+	context.AfterFunc(ctx, func() { ln.Close() })
+	log.Println("Helper listening on", path)
+
+	slots := make(chan struct{}, maxHelperConns)
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		// Callers are checked before anything is read from them, so a user
+		// who may not ask cannot tie up the helper either.
+		if !s.admit(conn) {
+			conn.Close()
+			<-slots
+			continue
+		}
+		go func() {
+			defer func() { <-slots }()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+// admit reports whether the caller at the other end of conn may use the
+// helper, telling it why not if it may not.
+func (s *helperServer) admit(conn *net.UnixConn) bool {
+	uid, err := peerUID(conn)
+	if err != nil {
+		log.Println("Helper: reading peer credentials:", err)
+		return false
+	}
+	if s.allowedUIDs[uid] {
+		return true
+	}
+	log.Printf("Helper: refusing uid %d, which is not in helper.allow_users", uid)
+	conn.SetWriteDeadline(time.Now().Add(helperIOTimeout))
+	json.NewEncoder(conn).Encode(helperResponse{Denied: true, Error: fmt.Sprintf("uid %d may not use the helper", uid)})
+	return false
+}
+
+// handle answers one request from an admitted caller.
+func (s *helperServer) handle(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+
+	conn.SetReadDeadline(time.Now().Add(helperIOTimeout))
+	var req helperRequest
+	if err := json.NewDecoder(io.LimitReader(conn, maxHelperRequest)).Decode(&req); err != nil {
+		conn.SetWriteDeadline(time.Now().Add(helperIOTimeout))
+		enc.Encode(helperResponse{Error: "malformed request: " + err.Error()})
+		return
+	}
+	resp := s.answer(ctx, req)
+	conn.SetWriteDeadline(time.Now().Add(helperIOTimeout))
+	enc.Encode(resp)
+}
+
+func (s *helperServer) answer(ctx context.Context, req helperRequest) helperResponse {
+	switch req.Query {
+	case helperRun:
+		if len(req.Command) == 0 || !s.allowed[strings.Join(req.Command, "\x00")] {
+			return helperResponse{Denied: true, Error: fmt.Sprintf("%q is not a command the helper runs", strings.Join(req.Command, " "))}
+		}
+		timeout := s.cfg.Timeouts.Collect
+		if req.Command[0] == "nmap" {
+			timeout = s.cfg.Scanner.Timeout
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		out, err := execRunner{}.Output(ctx, req.Command[0], req.Command[1:]...)
+		var exitErr *exitError
+		switch {
+		case errors.As(err, &exitErr):
+			return helperResponse{ExitStatus: exitErr.msg, Stdout: out, Stderr: exitErr.stderr}
+		case errors.Is(err, exec.ErrNotFound):
+			return helperResponse{NotFound: true}
+		case err != nil:
+			return helperResponse{Error: err.Error()}
+		}
+		return helperResponse{Stdout: out}
+	case helperSocketOwners:
+		owners, err := readSocketOwners()
+		if err != nil {
+			return helperResponse{Error: err.Error()}
+		}
+		return helperResponse{Owners: owners}
+	}
+	return helperResponse{Denied: true, Error: fmt.Sprintf("unknown query %q", req.Query)}
+}
+
+// peerUID returns the uid of the process at the other end of conn, as
+// recorded by the kernel when it connected.
+func peerUID(conn *net.UnixConn) (uint32, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return cred.Uid, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startHelper serves the helper on a socket in a temporary directory,
+// admitting the given uids.
+func startHelper(t *testing.T, uids ...uint32) *helperClient {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Helper.Socket = filepath.Join(t.TempDir(), "helper.sock")
+	s := &helperServer{cfg: &cfg, allowedUIDs: make(map[uint32]bool), allowed: make(map[string]bool)}
+	for _, uid := range uids {
+		s.allowedUIDs[uid] = true
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	for i := 0; ; i++ {
+		if _, err := os.Stat(cfg.Helper.Socket); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return &helperClient{socket: cfg.Helper.Socket}
+}
+
+func TestHelperAnswersAllowedUsers(t *testing.T) {
+	useFixtures(t, "laptop")
+	h := startHelper(t, uint32(os.Getuid()))
+
+	owners, err := h.socketOwners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pids := owners[40213]; len(pids) != 2 {
+		t.Errorf("owners of 40213 = %v, want the two sshd processes", pids)
+	}
+
+	_, err = h.run(context.Background(), []string{"sh", "-c", "id"})
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("running a command off the allowlist: err = %v, want permission denied", err)
+	}
+}
+
+func TestHelperRefusesOtherUsers(t *testing.T) {
+	h := startHelper(t, uint32(os.Getuid())+1)
+
+	_, err := h.socketOwners()
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("err = %v, want permission denied", err)
+	}
+
+	// A refused caller gets its answer without sending anything, and is
+	// disconnected.
+	conn, err := net.Dial("unix", h.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var resp helperResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil || !resp.Denied {
+		t.Fatalf("response = %+v, %v, want a refusal", resp, err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open after the refusal")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// hostRoot is prepended to every /proc, /sys and /etc path the collectors
+// read. It is "/" on a normal install; in a container with the host's root
+// mounted at /host it is "/host", and pointing it at a fixture tree runs the
+// collectors against recorded files.
+var hostRoot = "/"
+
+// setHostRoot makes the native readers, and gopsutil through its HOST_*
+// variables, read the host filesystem under root. HOST_* variables already
+// set in the environment are left alone.
+func setHostRoot(root string) {
+	hostRoot = root
+	if root == "/" {
+		return
+	}
+	for env, dir := range map[string]string{
+		"HOST_ROOT": "/",
+		"HOST_PROC": "/proc",
+		"HOST_SYS":  "/sys",
+		"HOST_ETC":  "/etc",
+		"HOST_VAR":  "/var",
+		"HOST_RUN":  "/run",
+		"HOST_DEV":  "/dev",
+	} {
+		if _, ok := os.LookupEnv(env); !ok {
+			os.Setenv(env, filepath.Join(root, dir))
+		}
+	}
+}
+
+// hostPath returns path as seen under hostRoot.
+func hostPath(path string) string {
+	return filepath.Join(hostRoot, path)
+}
+
+// readHostFile returns the contents of path under hostRoot, trimmed of
+// surrounding whitespace.
+func readHostFile(path string) (string, error) {
+	data, err := os.ReadFile(hostPath(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readHostname returns the host's name from /etc/hostname under hostRoot.
+// /proc/sys/kernel/hostname would give the name of the agent's own UTS
+// namespace, which in a container is the container's. Without the file the
+// agent's own hostname is used.
+func readHostname() (string, error) {
+	if name, err := readHostFile("/etc/hostname"); err == nil && name != "" {
+		return name, nil
+	}
+	return os.Hostname()
+}
+
+// readHostInt reads a sysfs attribute holding a single integer.
+func readHostInt(path string) (int64, error) {
+	s, err := readHostFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// mount is one line of /proc/mounts.
+type mount struct {
+	Device  string
+	Point   string
+	FSType  string
+	Options []string
+}
+
+// readMounts lists the host's mounts. PID 1's view is used so that in a
+// container the host's mounts are reported rather than the container's.
+func readMounts() ([]mount, error) {
+	f, err := os.Open(hostPath("/proc/1/mounts"))
+	if err != nil {
+		if f, err = os.Open(hostPath("/proc/self/mounts")); err != nil {
+			return nil, err
+		}
+	}
+	defer f.Close()
+
+	var mounts []mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		mounts = append(mounts, mount{
+			Device:  unescapeMountField(fields[0]),
+			Point:   unescapeMountField(fields[1]),
+			FSType:  fields[2],
+			Options: strings.Split(fields[3], ","),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountField undoes the octal escaping of spaces, tabs, newlines and
+// backslashes in /proc/mounts.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readOSRelease returns the fields of os-release(5), unquoted.
+func readOSRelease() (map[string]string, error) {
+	data, err := os.ReadFile(hostPath("/etc/os-release"))
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(hostPath("/usr/lib/os-release"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	release := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		release[key] = value
+	}
+	return release, nil
+}
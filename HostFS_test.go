@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// useFixtures points the collectors at testdata/host and answers commands
+// from testdata/commands/<scenario>. An empty scenario has no commands
+// recorded, as on a host where none of the tools is installed.
+func useFixtures(t *testing.T, scenario string) {
+	t.Helper()
+	dir := t.TempDir()
+	if scenario != "" {
+		dir = filepath.Join("testdata", "commands", scenario)
+	}
+	oldRoot, oldRunner, oldHelper := hostRoot, runner, helper
+	hostRoot, runner, helper = filepath.Join("testdata", "host"), replayRunner{dir: dir}, nil
+	t.Cleanup(func() {
+		hostRoot, runner, helper = oldRoot, oldRunner, oldHelper
+	})
+}
+
+// checkGolden compares got, as indented JSON, with testdata/golden/<name>.json.
+// Run the tests with -update to rewrite the file after an intended change.
+func checkGolden(t *testing.T, name string, got interface{}) {
+	t.Helper()
+	data, err := json.MarshalIndent(got, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, '\n')
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("%s differs from %s:\n%s", name, path, data)
+	}
+}
+
+// collected is what a collector function returned, the way it ends up in a
+// snapshot.
+type collected struct {
+	Status  string            `json:"status"`
+	Message string            `json:"message,omitempty"`
+	Value   interface{}       `json:"value,omitempty"`
+	Display map[string]string `json:"display,omitempty"`
+}
+
+func newCollected(value interface{}, err error) collected {
+	if err != nil {
+		return collected{Status: statusOf(err), Message: err.Error()}
+	}
+	c := collected{Status: statusOK, Value: value}
+	if d, ok := value.(displayer); ok {
+		c.Display = d.display()
+	}
+	return c
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	identityFile = "identity"
+	// maxIdentityLen is MySQL's limit on table names, which the server
+	// derives from the identity.
+	maxIdentityLen = 64
+)
+
+var (
+	machineIDPaths   = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+	identityRe       = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	unsafeIdentityRe = regexp.MustCompile(`[^a-z0-9_]+`)
+)
+
+// validIdentity reports whether name can be used as the snapshot key, i.e.
+// as a MySQL table name without quoting surprises.
+func validIdentity(name string) bool {
+	return len(name) <= maxIdentityLen && identityRe.MatchString(name)
+}
+
+// resolveIdentity returns the agent identity. A name set in the config wins;
+// otherwise the identity saved in stateDir by an earlier run is reused, and
+// only on first start is one derived from the hostname and machine-id. The
+// result is saved so history stays attached to the host across restarts,
+// even if its hostname later changes.
+func resolveIdentity(configured, stateDir string) (string, error) {
+	path := filepath.Join(stateDir, identityFile)
+
+	saved := ""
+	if data, err := os.ReadFile(path); err == nil {
+		saved = strings.TrimSpace(string(data))
+		if !validIdentity(saved) {
+			log.Printf("Ignoring invalid saved identity %q in %s", saved, path)
+			saved = ""
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id := configured
+	if id == "" {
+		id = saved
+	}
+	if id == "" {
+		derived, err := deriveIdentity()
+		if err != nil {
+			return "", err
+		}
+		id = derived
+	}
+
+	if id != saved {
+		if err := os.MkdirAll(stateDir, 0o750); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(path, []byte(id+"\n"), 0o640); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+// deriveIdentity builds "<hostname>_<first 12 hex digits of the machine-id>".
+// Both are the host's, read under hostRoot, so an agent in a container is
+// named after the host it watches. Hosts without a machine-id get a random
+// one instead, which is stable once resolveIdentity has saved it.
+func deriveIdentity() (string, error) {
+	hostname, err := readHostname()
+	if err != nil {
+		return "", err
+	}
+
+	machineID := ""
+	for _, p := range machineIDPaths {
+		if data, err := readHostFile(p); err == nil {
+			if machineID = data; machineID != "" {
+				break
+			}
+		}
+	}
+	if machineID == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		machineID = hex.EncodeToString(buf)
+	}
+	if len(machineID) > 12 {
+		machineID = machineID[:12]
+	}
+
+	host := strings.Trim(unsafeIdentityRe.ReplaceAllString(strings.ToLower(hostname), "_"), "_")
+	if host == "" {
+		host = "host"
+	}
+	if max := maxIdentityLen - len(machineID) - 1; len(host) > max {
+		host = host[:max]
+	}
+	id := fmt.Sprintf("%s_%s", host, unsafeIdentityRe.ReplaceAllString(strings.ToLower(machineID), ""))
+	if !validIdentity(id) {
+		return "", fmt.Errorf("derived identity %q is not a valid table name", id)
+	}
+	return id, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// identityHost points hostRoot at a new tree holding the given files.
+func identityHost(t *testing.T, files map[string]string) {
+	t.Helper()
+	useFixtures(t, "")
+	hostRoot = t.TempDir()
+	for path, content := range files {
+		writeHostFile(t, path, content)
+	}
+}
+
+func writeHostFile(t *testing.T, path, content string) {
+	t.Helper()
+	full := hostPath(path)
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testMachineID = "0123456789abcdef0123456789abcdef\n"
+
+func TestDeriveIdentity(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"hostname and machine-id", map[string]string{"/etc/hostname": "Web-01.example.com\n", "/etc/machine-id": testMachineID}, "web_01_example_com_0123456789ab"},
+		{"dbus machine-id", map[string]string{"/etc/hostname": "web01\n", "/var/lib/dbus/machine-id": "fedcba9876543210\n"}, "web01_fedcba987654"},
+		{"empty machine-id", map[string]string{"/etc/hostname": "web01\n", "/etc/machine-id": "\n", "/var/lib/dbus/machine-id": testMachineID}, "web01_0123456789ab"},
+		{"nothing usable in the hostname", map[string]string{"/etc/hostname": "--\n", "/etc/machine-id": testMachineID}, "host_0123456789ab"},
+		{"long hostname", map[string]string{"/etc/hostname": strings.Repeat("a", 100) + "\n", "/etc/machine-id": testMachineID}, strings.Repeat("a", 51) + "_0123456789ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identityHost(t, tt.files)
+			got, err := deriveIdentity()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("deriveIdentity() = %q, want %q", got, tt.want)
+			}
+			if len(got) > maxIdentityLen || !validIdentity(got) {
+				t.Errorf("%q is not a valid identity", got)
+			}
+		})
+	}
+}
+
+func TestDeriveIdentityWithoutMachineID(t *testing.T) {
+	identityHost(t, map[string]string{"/etc/hostname": "web01\n"})
+	first, err := deriveIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^web01_[0-9a-f]{12}$`).MatchString(first) {
+		t.Errorf("deriveIdentity() = %q, want web01 and 12 random hex digits", first)
+	}
+	if second, _ := deriveIdentity(); second == first {
+		t.Errorf("two random identities alike: %q", first)
+	}
+
+	// once saved, the random identity is kept
+	stateDir := t.TempDir()
+	id, err := resolveIdentity("", stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := resolveIdentity("", stateDir); err != nil || again != id {
+		t.Errorf("resolveIdentity() = %q, %v after restart, want %q", again, err, id)
+	}
+}
+
+func TestResolveIdentity(t *testing.T) {
+	identityHost(t, map[string]string{"/etc/hostname": "web01\n", "/etc/machine-id": testMachineID})
+	stateDir := filepath.Join(t.TempDir(), "state")
+	resolve := func(step, configured, want string) {
+		t.Helper()
+		got, err := resolveIdentity(configured, stateDir)
+		if err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if got != want {
+			t.Errorf("%s: resolveIdentity() = %q, want %q", step, got, want)
+		}
+		saved, err := os.ReadFile(filepath.Join(stateDir, identityFile))
+		if err != nil || string(saved) != want+"\n" {
+			t.Errorf("%s: saved %q, %v", step, saved, err)
+		}
+	}
+
+	resolve("first start", "", "web01_0123456789ab")
+	writeHostFile(t, "/etc/hostname", "db02\n")
+	resolve("hostname changed", "", "web01_0123456789ab")
+	resolve("configured name", "billing_db", "billing_db")
+	resolve("configured name removed", "", "billing_db")
+
+	if err := os.WriteFile(filepath.Join(stateDir, identityFile), []byte("not valid!\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	resolve("invalid saved identity", "", "db02_0123456789ab")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"log"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+func init() {
+	registerCollector("base", &loginCollector{}, 10*time.Second)
+	privilegedCollectors["logins"] = "failed logins are read from btmp, which only root and the utmp group may read"
+}
+
+// Layout of struct utmp on Linux, shared by utmp, wtmp and btmp.
+const (
+	utmpRecordSize = 384
+	utLoginProcess = 6
+	utUserProcess  = 7
+)
+
+// Logins lists the users logged in right now. New logins and failed login
+// attempts are reported as login and login_failed events.
+type Logins struct {
+	Sessions []LoginSession `json:"sessions"`
+}
+
+// LoginSession is one entry of utmp, as shown by who(1) and w(1).
+type LoginSession struct {
+	User        string `json:"user"`
+	TTY         string `json:"tty"`
+	Host        string `json:"host,omitempty"`
+	PID         int    `json:"pid,omitempty"`
+	LoginTime   string `json:"login_time"`
+	IdleSeconds *int64 `json:"idle_seconds,omitempty"`
+}
+
+// LoginRecord is the data of login and login_failed events.
+type LoginRecord struct {
+	User string `json:"user"`
+	TTY  string `json:"tty,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+// utmpRecord is the part of a struct utmp the collector uses.
+type utmpRecord struct {
+	Type int16
+	PID  int32
+	Line string
+	User string
+	Host string
+	Time time.Time
+}
+
+func parseUtmpRecord(b []byte) utmpRecord {
+	return utmpRecord{
+		Type: int16(binary.LittleEndian.Uint16(b[0:])),
+		PID:  int32(binary.LittleEndian.Uint32(b[4:])),
+		Line: cString(b[8:40]),
+		User: cString(b[44:76]),
+		Host: cString(b[76:332]),
+		Time: time.Unix(int64(int32(binary.LittleEndian.Uint32(b[340:]))), 0),
+	}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseUtmp(data []byte) []utmpRecord {
+	records := make([]utmpRecord, 0, len(data)/utmpRecordSize)
+	for len(data) >= utmpRecordSize {
+		records = append(records, parseUtmpRecord(data[:utmpRecordSize]))
+		data = data[utmpRecordSize:]
+	}
+	return records
+}
+
+// wholeUtmpRecords is the readNew complete func for utmp files.
+func wholeUtmpRecords(b []byte) int {
+	return len(b) - len(b)%utmpRecordSize
+}
+
+// loginCollector reads current sessions from utmp and follows wtmp and
+// btmp for new logins and failed attempts. Its read positions are saved in
+// the state dir.
+type loginCollector struct {
+	loaded  bool
+	cursors map[string]*fileCursor // by log path below hostRoot
+}
+
+const loginStateFile = "logins.json"
+
+func (c *loginCollector) Name() string {
+	return "logins"
+}
+
+func (c *loginCollector) Collect(ctx context.Context) (interface{}, error) {
+	data, err := os.ReadFile(hostPath("/run/utmp"))
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(hostPath("/var/run/utmp"))
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Logins{}, unavailable("no utmp, this system does not record logins")
+		}
+		return Logins{}, err
+	}
+
+	now := time.Now()
+	logins := Logins{Sessions: []LoginSession{}}
+	for _, r := range parseUtmp(data) {
+		if r.Type != utUserProcess || r.User == "" {
+			continue
+		}
+		// Entries of sessions that ended without cleaning up after themselves
+		if _, err := os.Stat(hostPath("/proc/" + strconv.Itoa(int(r.PID)))); r.PID > 0 && os.IsNotExist(err) {
+			continue
+		}
+		session := LoginSession{
+			User:      r.User,
+			TTY:       r.Line,
+			Host:      r.Host,
+			PID:       int(r.PID),
+			LoginTime: r.Time.Format(time.RFC3339),
+		}
+		// Idle time is how long ago the terminal was last read from, as w(1) has it
+		var st syscall.Stat_t
+		if err := syscall.Stat(hostPath("/dev/"+r.Line), &st); err == nil {
+			idle := int64(now.Sub(time.Unix(st.Atim.Sec, st.Atim.Nsec)).Seconds())
+			if idle < 0 {
+				idle = 0
+			}
+			session.IdleSeconds = &idle
+		}
+		logins.Sessions = append(logins.Sessions, session)
+	}
+
+	c.follow()
+	return logins, nil
+}
+
+// historyFailures rate-limits logging of wtmp and btmp read failures, which
+// only cost the events, not the sessions reported.
+var historyFailures = newFailureLog("reading")
+
+// follow raises events for the records appended to wtmp and btmp since the
+// last run. A log that does not exist is skipped quietly; one that cannot be
+// read is logged.
+func (c *loginCollector) follow() {
+	if !c.loaded {
+		c.cursors = make(map[string]*fileCursor)
+		if err := loadState(loginStateFile, &c.cursors); err != nil {
+			log.Println("Ignoring unreadable login state:", err)
+		}
+		c.loaded = true
+	}
+
+	for _, l := range []struct {
+		path, event string
+		types       []int16
+	}{
+		{"/var/log/wtmp", "login", []int16{utUserProcess}},
+		// btmp is only readable by root and the utmp group
+		{"/var/log/btmp", "login_failed", []int16{utLoginProcess, utUserProcess}},
+	} {
+		cur := c.cursors[l.path]
+		if cur == nil {
+			cur = &fileCursor{}
+			c.cursors[l.path] = cur
+		}
+		data, err := readNew(hostPath(l.path), cur, wholeUtmpRecords)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		field := Field{Status: statusOf(err)}
+		if err != nil {
+			field.Message = err.Error()
+		}
+		historyFailures.record(l.path, field)
+		if err != nil {
+			continue
+		}
+		for _, r := range parseUtmp(data) {
+			for _, t := range l.types {
+				if r.Type == t {
+					events.emit("logins", l.event, r.Time, LoginRecord{User: r.User, TTY: r.Line, Host: r.Host})
+					break
+				}
+			}
+		}
+	}
+	saveCollectorState(loginStateFile, c.cursors)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUtmp(t *testing.T) {
+	for _, path := range []string{"/run/utmp", "/var/log/wtmp", "/var/log/btmp"} {
+		t.Run(path, func(t *testing.T) {
+			useFixtures(t, "laptop")
+			data, err := os.ReadFile(hostPath(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			records := parseUtmp(data)
+			if len(records) != len(data)/utmpRecordSize {
+				t.Errorf("%d records from %d bytes", len(records), len(data))
+			}
+			for i := range records {
+				records[i].Time = records[i].Time.UTC()
+			}
+			checkGolden(t, "utmp_"+path[len(path)-4:], records)
+		})
+	}
+}
+
+func TestParseUtmpTornRecord(t *testing.T) {
+	useFixtures(t, "laptop")
+	data, err := os.ReadFile(hostPath("/var/log/wtmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := data[:len(data)-10]
+	if n := wholeUtmpRecords(torn); n != len(data)-utmpRecordSize {
+		t.Errorf("wholeUtmpRecords = %d, want %d", n, len(data)-utmpRecordSize)
+	}
+	if got, want := len(parseUtmp(torn)), len(data)/utmpRecordSize-1; got != want {
+		t.Errorf("%d records from a torn file, want %d", got, want)
+	}
+}
+
+func TestCString(t *testing.T) {
+	if got := cString([]byte("pts/0\x00\x00garbage")); got != "pts/0" {
+		t.Errorf("cString = %q", got)
+	}
+	if got := cString([]byte("unterminated")); got != "unterminated" {
+		t.Errorf("cString = %q", got)
+	}
+}
+
+// TestLoginHistoryUnreadable checks that a btmp the agent cannot read is
+// logged, once, rather than passed over in silence.
+func TestLoginHistoryUnreadable(t *testing.T) {
+	useFixtures(t, "")
+	hostRoot = t.TempDir()
+	// a directory opens fine but fails to read, even for root
+	if err := os.MkdirAll(filepath.Join(hostRoot, "var", "log", "btmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	oldFailures := historyFailures
+	historyFailures = newFailureLog("reading")
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() {
+		historyFailures = oldFailures
+		log.SetOutput(os.Stderr)
+	})
+
+	c := &loginCollector{}
+	for run := 0; run < 3; run++ {
+		c.follow()
+	}
+	if n := strings.Count(logged.String(), "Error reading /var/log/btmp"); n != 1 {
+		t.Errorf("btmp failure logged %d times:\n%s", n, logged.String())
+	}
+	if strings.Contains(logged.String(), "wtmp") {
+		t.Errorf("missing wtmp logged:\n%s", logged.String())
+	}
+}
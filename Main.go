@@ -1,154 +1,267 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net"
-	"os/exec"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
-type SystemInfo struct {
-	Hostname    string `json:"hostname"`
-	IP          string `json:"ip"`
-	CPUModel    string `json:"cpu_model"`
-	TotalMemory string `json:"total_memory"`
-	UsedMemory  string `json:"used_memory"`
-	Uptime      string `json:"uptime"`
-	WiFi        string `json:"wifi"`
-	Battery     string `json:"battery"`
-	SSHInfo     string `json:"ssh_info"`
-	Timestamp   string `json:"timestamp"`
+// SystemInfo is one snapshot: schema_version, timestamp, events and a Field
+// per collector under fields, plus the version 1 display strings.
+type SystemInfo map[string]interface{}
+
+func init() {
+	register("base", "hostname", time.Minute, getHostname)
+	register("base", "ip", 30*time.Second, getIPAddress)
+	register("base", "cpu_model", runOnce, getCPUModel)
+	register("base", "memory", 5*time.Second, getMemoryInfo)
+	register("base", "uptime", 5*time.Second, getUptime)
+	register("base", "wifi", 30*time.Second, getWiFiInfo)
+	register("base", "battery", 30*time.Second, getBatteryInfo)
+	register("base", "ssh_info", 10*time.Second, getSSHInfo)
 }
 
-type SystemInfoWrapper struct {
-	System1Info SystemInfo `json:"Thangavi_info"`
+// Memory is physical memory usage.
+type Memory struct {
+	TotalBytes  uint64  `json:"total_bytes"`
+	UsedBytes   uint64  `json:"used_bytes"`
+	UsedPercent float64 `json:"used_percent"`
 }
 
-func getCPUModel() (string, error) {
-	cpuInfo, err := cpu.Info()
+func (m Memory) display() map[string]string {
+	return map[string]string{
+		"total_memory": fmt.Sprintf("%.2f GB", float64(m.TotalBytes)/(1024*1024*1024)),
+		"used_memory":  fmt.Sprintf("%.2f GB (%.2f%%)", float64(m.UsedBytes)/(1024*1024*1024), m.UsedPercent),
+	}
+}
+
+// Uptime is the time since boot.
+type Uptime struct {
+	Seconds uint64 `json:"seconds"`
+}
+
+func (u Uptime) display() map[string]string {
+	return map[string]string{"uptime": (time.Duration(u.Seconds) * time.Second).String()}
+}
+
+// WiFi is the wireless link of the first interface with an ESSID.
+type WiFi struct {
+	Interface string `json:"interface"`
+	ESSID     string `json:"essid"`
+	SignalDBm *int   `json:"signal_dbm,omitempty"`
+
+	line string // the iwconfig line shown by the old agent
+}
+
+func (w WiFi) display() map[string]string {
+	return map[string]string{"wifi": w.line}
+}
+
+// Battery is the charge state of the primary battery.
+type Battery struct {
+	State          string   `json:"state"`
+	Percent        *float64 `json:"percent,omitempty"`
+	SecondsToEmpty *int64   `json:"seconds_to_empty,omitempty"`
+	SecondsToFull  *int64   `json:"seconds_to_full,omitempty"`
+
+	summary string // the upower lines shown by the old agent
+}
+
+func (b Battery) display() map[string]string {
+	return map[string]string{"battery": b.summary}
+}
+
+func getHostname(ctx context.Context) (string, error) {
+	return readHostname()
+}
+
+func getCPUModel(ctx context.Context) (string, error) {
+	cpuInfo, err := cpu.InfoWithContext(ctx)
 	if err != nil {
 		return "", err
 	}
 	if len(cpuInfo) > 0 {
 		return cpuInfo[0].ModelName, nil
 	}
-	return "Unknown", nil
+	return "", unavailable("no CPU information")
 }
 
-func getMemoryInfo() (string, string, error) {
-	vmStat, err := mem.VirtualMemory()
+func getMemoryInfo(ctx context.Context) (Memory, error) {
+	vmStat, err := mem.VirtualMemoryWithContext(ctx)
 	if err != nil {
-		return "", "", err
+		return Memory{}, err
 	}
-	totalMem := fmt.Sprintf("%.2f GB", float64(vmStat.Total)/(1024*1024*1024))
-	usedMem := fmt.Sprintf("%.2f GB (%.2f%%)", float64(vmStat.Used)/(1024*1024*1024), vmStat.UsedPercent)
-	return totalMem, usedMem, nil
+	return Memory{TotalBytes: vmStat.Total, UsedBytes: vmStat.Used, UsedPercent: vmStat.UsedPercent}, nil
 }
 
-func getUptime() (string, error) {
-	uptime, err := host.Uptime()
+func getUptime(ctx context.Context) (Uptime, error) {
+	data, err := readHostFile("/proc/uptime")
 	if err != nil {
-		return "", err
+		return Uptime{}, err
+	}
+	seconds, _, _ := strings.Cut(data, " ")
+	uptime, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return Uptime{}, fmt.Errorf("unexpected /proc/uptime %q", data)
 	}
-	uptimeDuration := time.Duration(uptime) * time.Second
-	return uptimeDuration.String(), nil
+	return Uptime{Seconds: uint64(uptime)}, nil
 }
 
-func getWiFiInfo() (string, error) {
-	out, err := exec.Command("iwconfig").Output()
+var (
+	essidRe       = regexp.MustCompile(`^(\S+)\s.*ESSID:"([^"]*)"`)
+	signalLevelRe = regexp.MustCompile(`Signal level[=:]\s*(-?\d+)\s*dBm`)
+)
+
+func getWiFiInfo(ctx context.Context) (WiFi, error) {
+	out, err := runner.Output(ctx, "iwconfig")
 	if err != nil {
-		return "", fmt.Errorf("WiFi information command not found")
-	}
-	wifiInfo := string(out)
-	if strings.Contains(wifiInfo, "ESSID") {
-		lines := strings.Split(wifiInfo, "\n")
-		for _, line := range lines {
-			if strings.Contains(line, "ESSID") || strings.Contains(line, "Signal level") {
-				return line, nil
+		return WiFi{}, commandError("iwconfig", err)
+	}
+
+	var wifi WiFi
+	for _, line := range strings.Split(string(out), "\n") {
+		if m := essidRe.FindStringSubmatch(line); m != nil && wifi.Interface == "" {
+			wifi.Interface, wifi.ESSID, wifi.line = m[1], m[2], line
+			continue
+		}
+		if m := signalLevelRe.FindStringSubmatch(line); m != nil && wifi.Interface != "" && wifi.SignalDBm == nil {
+			if dbm, err := strconv.Atoi(m[1]); err == nil {
+				wifi.SignalDBm = &dbm
 			}
 		}
 	}
-	return "No WiFi info available", nil
+	if wifi.Interface == "" {
+		return WiFi{}, unavailable("no wireless interface")
+	}
+	return wifi, nil
+}
+
+// powerSupplyStates maps the sysfs battery status to the state names upower
+// reports, which the old agent sent.
+var powerSupplyStates = map[string]string{
+	"Charging":     "charging",
+	"Discharging":  "discharging",
+	"Full":         "fully-charged",
+	"Not charging": "pending-charge",
 }
 
-func getBatteryInfo() (string, error) {
-	out, err := exec.Command("upower", "-i", "/org/freedesktop/UPower/devices/battery_BAT0").Output()
+func getBatteryInfo(ctx context.Context) (Battery, error) {
+	dir, err := findBattery()
 	if err != nil {
-		return "", fmt.Errorf("Battery information command not found")
-	}
-
-	lines := strings.Split(string(out), "\n")
-	var batteryInfo []string
-	var state, timeToEmpty, timeToFull, percentage string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line) // Clean up whitespace
-		if strings.Contains(line, "state") {
-			state = strings.ReplaceAll(line, " ", "") // Remove spaces
-		} else if strings.Contains(line, "time to empty") {
-			timeToEmpty = strings.TrimSpace(line)
-		} else if strings.Contains(line, "time to full") {
-			timeToFull = strings.TrimSpace(line)
-		} else if strings.Contains(line, "percentage") {
-			percentage = strings.ReplaceAll(line, " ", "") // Remove spaces
-		}
+		return Battery{}, err
+	}
+	status, err := readHostFile(dir + "/status")
+	if err != nil {
+		return Battery{}, err
+	}
+
+	battery := Battery{State: powerSupplyStates[status]}
+	if battery.State == "" {
+		battery.State = strings.ToLower(status)
+	}
+	if capacity, err := readHostInt(dir + "/capacity"); err == nil {
+		p := float64(capacity)
+		battery.Percent = &p
 	}
 
-	batteryInfo = append(batteryInfo, state)
-	if timeToFull != "" {
-		batteryInfo = append(batteryInfo, timeToFull)
-	} else if timeToEmpty != "" {
-		batteryInfo = append(batteryInfo, timeToEmpty)
+	// Batteries report either energy (µWh) and power (µW) or charge (µAh)
+	// and current (µA); both give the remaining time the same way.
+	now, full, rate := readBatteryLevels(dir, "energy_now", "energy_full", "power_now")
+	if rate <= 0 {
+		now, full, rate = readBatteryLevels(dir, "charge_now", "charge_full", "current_now")
 	}
-	if percentage != "" {
-		batteryInfo = append(batteryInfo, percentage)
+	if rate > 0 {
+		switch battery.State {
+		case "discharging":
+			seconds := now * 3600 / rate
+			battery.SecondsToEmpty = &seconds
+		case "charging":
+			if full > now {
+				seconds := (full - now) * 3600 / rate
+				battery.SecondsToFull = &seconds
+			}
+		}
 	}
 
-	if len(batteryInfo) > 0 {
-		return strings.Join(batteryInfo, ", "), nil
+	batteryInfo := []string{"state:" + battery.State}
+	if battery.SecondsToFull != nil {
+		batteryInfo = append(batteryInfo, "time to full: "+upowerDuration(*battery.SecondsToFull))
+	} else if battery.SecondsToEmpty != nil {
+		batteryInfo = append(batteryInfo, "time to empty: "+upowerDuration(*battery.SecondsToEmpty))
+	}
+	if battery.Percent != nil {
+		batteryInfo = append(batteryInfo, fmt.Sprintf("percentage:%g%%", *battery.Percent))
 	}
-	return "No Battery info available", nil
+	battery.summary = strings.Join(batteryInfo, ", ")
+	return battery, nil
 }
 
-func getSSHInfo() (string, error) {
-	out, err := exec.Command("ss", "-tuna").Output()
+// findBattery returns the sysfs directory of the first system battery,
+// skipping the batteries of peripherals such as wireless mice.
+func findBattery() (string, error) {
+	entries, err := os.ReadDir(hostPath("/sys/class/power_supply"))
 	if err != nil {
-		return "", fmt.Errorf("SSH command not found")
+		if os.IsNotExist(err) {
+			return "", unavailable("no battery")
+		}
+		return "", err
 	}
-
-	ssOut := string(out)
-	var connectedIPs []string
-	lines := strings.Split(ssOut, "\n")
-
-	for _, line := range lines {
-		if strings.Contains(line, ":22") && strings.Contains(line, "ESTAB") {
-			parts := strings.Fields(line)
-			if len(parts) >= 5 {
-				remoteAddress := parts[len(parts)-1]
-				ip := strings.Split(remoteAddress, ":")[0]
-				if ip != "127.0.0.1" && ip != "localhost" {
-					connectedIPs = append(connectedIPs, ip)
-				}
-			}
+	for _, e := range entries {
+		dir := "/sys/class/power_supply/" + e.Name()
+		if typ, _ := readHostFile(dir + "/type"); typ != "Battery" {
+			continue
 		}
+		if scope, _ := readHostFile(dir + "/scope"); scope == "Device" {
+			continue
+		}
+		return dir, nil
+	}
+	return "", unavailable("no battery")
+}
+
+// readBatteryLevels reads the current and full level and the rate of change
+// of a battery, in whatever unit pair the names refer to. rate is 0 when any
+// is missing.
+func readBatteryLevels(dir, nowName, fullName, rateName string) (now, full, rate int64) {
+	now, err1 := readHostInt(dir + "/" + nowName)
+	full, err2 := readHostInt(dir + "/" + fullName)
+	rate, err3 := readHostInt(dir + "/" + rateName)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, 0, 0
+	}
+	if rate < 0 {
+		rate = -rate // some drivers report discharge as negative current
 	}
+	return now, full, rate
+}
 
-	if len(connectedIPs) > 0 {
-		return fmt.Sprintf("Active SSH connections detected. Connected IPs: %s", strings.Join(connectedIPs, ", ")), nil
+// upowerDuration formats seconds the way upower -i does, e.g. "3.2 hours".
+func upowerDuration(seconds int64) string {
+	switch {
+	case seconds >= 3600:
+		return fmt.Sprintf("%.1f hours", float64(seconds)/3600)
+	case seconds >= 60:
+		return fmt.Sprintf("%.1f minutes", float64(seconds)/60)
 	}
-	return "No active SSH connections", nil
+	return fmt.Sprintf("%d seconds", seconds)
 }
 
 // Function to get the system IP address
-func getIPAddress() (string, error) {
+func getIPAddress(ctx context.Context) (string, error) {
 	interfaces, err := net.Interfaces()
 	if err != nil {
 		return "", err
@@ -174,107 +287,164 @@ func getIPAddress() (string, error) {
 			}
 		}
 	}
-	return "No IP address found", nil
+	return "", unavailable("no IPv4 address on any interface")
 }
 
-func main() {
-	// WebSocket connection setup
-	wsURL := "ws://localhost:8080/WebSockCon/serverws" // Replace with your actual WebSocket server URL
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
-	if err != nil {
-		log.Fatal("Error connecting to WebSocket:", err)
+// publish sends data, spooling it if the server is unreachable. While older
+// snapshots are still spooled, data queues behind them to keep order.
+func publish(conn *connManager, sp *spool, data []byte) {
+	if sp.Empty() {
+		err := conn.Send(data)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, errNotConnected) {
+			log.Println("Error sending message:", err)
+		}
 	}
-	defer conn.Close()
+	if err := sp.Append(data); err != nil {
+		log.Println("Error spooling message, it is lost:", err)
+		return
+	}
+	if err := sp.Replay(conn.Send); err != nil && !errors.Is(err, errNotConnected) {
+		log.Println("Error replaying spooled messages:", err)
+	}
+}
 
-	// Infinite loop to update system information every 1 minute
-	for {
-		// Get Hostname
-		hostInfo, err := host.Info()
-		if err != nil {
-			log.Fatal("Error getting host info:", err)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate-config":
+			os.Exit(runValidateConfig(os.Args[2:]))
+		case "check-schema":
+			os.Exit(runCheckSchema(os.Args[2:]))
+		case "helper":
+			os.Exit(runHelper(os.Args[2:]))
+		case "bless-firewall":
+			os.Exit(runBlessFirewall(os.Args[2:]))
 		}
-		hostname := hostInfo.Hostname
+	}
 
-		// Get IP Address
-		ipAddress, err := getIPAddress()
-		if err != nil {
-			log.Fatal("Error getting IP address:", err)
-		}
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
 
-		// Get CPU model
-		cpuModel, err := getCPUModel()
-		if err != nil {
-			log.Fatal("Error getting CPU info:", err)
+	collectors, err := configuredCollectors(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	setHostRoot(cfg.HostRoot)
+	collectorStateDir = cfg.StateDir
+	if cfg.Helper.Socket != "" {
+		helper = &helperClient{socket: cfg.Helper.Socket}
+		runner = helperRunner{helper: helper, local: execRunner{}}
+	} else if os.Geteuid() != 0 {
+		for _, c := range collectors {
+			if why, ok := privilegedCollectors[c.Name()]; ok {
+				log.Printf("%s may report permission_denied when not run as root: %s", c.Name(), why)
+			}
 		}
+	}
 
-		// Get Memory info
-		totalMem, usedMem, err := getMemoryInfo()
-		if err != nil {
-			log.Fatal("Error getting memory info:", err)
-		}
+	agentID, err := resolveIdentity(cfg.Agent.Name, cfg.StateDir)
+	if err != nil {
+		log.Fatal("Error resolving agent identity:", err)
+	}
+	log.Println("Reporting as", agentID)
 
-		// Get System Uptime
-		uptime, err := getUptime()
-		if err != nil {
-			log.Fatal("Error getting uptime info:", err)
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// Get WiFi info
-		wifi, err := getWiFiInfo()
-		if err != nil {
-			log.Println(err)
-			wifi = "No WiFi info"
-		}
+	// WebSocket connection setup
+	conn := newConnManager(cfg.Server.URL, cfg.Timeouts)
+	go conn.Run(ctx)
 
-		// Get Battery info
-		battery, err := getBatteryInfo()
-		if err != nil {
-			log.Println(err)
-			battery = "No Battery info"
-		}
+	// Snapshots that cannot be sent are spooled and replayed in order later
+	sp, err := openSpool(filepath.Join(cfg.StateDir, "spool"), cfg.spoolOptions())
+	if err != nil {
+		log.Fatal("Error opening spool:", err)
+	}
+	defer sp.Close()
+
+	// Every collector runs on its own schedule; snapshots merge the latest results
+	sched := newScheduler(collectors, cfg.Workers)
+	sched.Start(ctx)
+
+	// Scan results are sent as messages of their own, between snapshots
+	var scans chan []byte
+	if cfg.Scanner.Enabled {
+		scans = make(chan []byte)
+		scanner := &netScanner{cfg: cfg.Scanner}
+		go scanner.Run(ctx, agentID, scans)
+	}
 
-		// Get SSH info
-		ssh, err := getSSHInfo()
-		if err != nil {
-			log.Println(err)
-			ssh = "No SSH info"
-		}
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		sysInfo := buildSnapshot(sched.Snapshot(), events.drain(), cfg.Display)
 
-		// Get Current Time
-		currentTime := time.Now().Format(time.RFC3339)
-
-		// Create a struct for system information
-		sysInfo := SystemInfo{
-			Hostname:    hostname,
-			IP:          ipAddress,
-			CPUModel:    cpuModel,
-			TotalMemory: totalMem,
-			UsedMemory:  usedMem,
-			Uptime:      uptime,
-			WiFi:        wifi,
-			Battery:     battery,
-			SSHInfo:     ssh,
-			Timestamp:   currentTime,
-		}
+		// Convert system information to JSON, wrapped under the agent identity
+		jsonData := encodeSnapshot(agentID, sysInfo)
 
-		// Wrap system info inside SystemInfoWrapper with the "system1_info" key
-		wrappedInfo := SystemInfoWrapper{
-			System1Info: sysInfo,
+		// Send the JSON data over WebSocket
+		publish(conn, sp, jsonData)
+
+		// Wait for the configured interval before sending the next update
+	wait:
+		for {
+			select {
+			case <-ticker.C:
+				break wait
+			case scan := <-scans:
+				publish(conn, sp, scan)
+			case <-ctx.Done():
+				return
+			}
 		}
+	}
+}
 
-		// Convert system information to JSON
-		jsonData, err := json.Marshal(wrappedInfo)
-		if err != nil {
-			log.Fatal("Error marshalling system info to JSON:", err)
+// configuredCollectors returns the collectors enabled by cfg, with their
+// configured intervals and timeouts.
+func configuredCollectors(cfg *Config) ([]registration, error) {
+	registered, err := enabledCollectors(cfg.CollectorSets)
+	if err != nil {
+		return nil, err
+	}
+	var collectors []registration
+	for _, r := range registered {
+		if !cfg.collectorEnabled(r.Name()) {
+			continue
 		}
-
-		// Send the JSON data over WebSocket
-		err = conn.WriteMessage(websocket.TextMessage, jsonData)
-		if err != nil {
-			log.Println("Error sending message:", err)
+		if c, ok := r.Collector.(configurable); ok {
+			if err := c.configure(cfg); err != nil {
+				return nil, fmt.Errorf("%s: %w", r.Name(), err)
+			}
 		}
+		r.interval = cfg.collectorInterval(r)
+		r.timeout = cfg.collectorTimeout(r.Name())
+		collectors = append(collectors, r)
+	}
+	return collectors, nil
+}
 
-		// Wait for 1 minute before sending the next update
-		time.Sleep(1 * time.Second)
+// runValidateConfig implements the validate-config command: it loads the
+// configuration exactly as the agent would and reports every problem found.
+func runValidateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	_, err := loadConfig(fs, args)
+	var errs configErrors
+	switch {
+	case errors.As(err, &errs):
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		return 1
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
+	fmt.Println("configuration OK")
+	return 0
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestGetHostname(t *testing.T) {
+	useFixtures(t, "")
+	if got, err := getHostname(context.Background()); err != nil || got != "web01" {
+		t.Errorf("getHostname() = %q, %v, want web01 from /etc/hostname", got, err)
+	}
+
+	// without /etc/hostname the agent's own name is used
+	hostRoot = t.TempDir()
+	want, _ := os.Hostname()
+	if got, err := getHostname(context.Background()); err != nil || got != want {
+		t.Errorf("getHostname() = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestGetWiFiInfo(t *testing.T) {
+	tests := []struct {
+		scenario string
+		status   string
+	}{
+		{"laptop", statusOK},
+		{"minimal", statusUnavailable}, // no wireless interface: empty stdout
+		{"server", statusUnavailable},
+		{"", statusUnavailable}, // iwconfig not installed
+	}
+	for _, tt := range tests {
+		name := tt.scenario
+		if name == "" {
+			name = "not_installed"
+		}
+		t.Run(name, func(t *testing.T) {
+			useFixtures(t, tt.scenario)
+			got := newCollected(getWiFiInfo(context.Background()))
+			if got.Status != tt.status {
+				t.Fatalf("status = %s (%s), want %s", got.Status, got.Message, tt.status)
+			}
+			checkGolden(t, "wifi_"+name, got)
+		})
+	}
+}
+
+func TestGetBatteryInfo(t *testing.T) {
+	useFixtures(t, "laptop")
+	checkGolden(t, "battery", newCollected(getBatteryInfo(context.Background())))
+}
+
+func TestGetUptime(t *testing.T) {
+	useFixtures(t, "laptop")
+	checkGolden(t, "uptime", newCollected(getUptime(context.Background())))
+}
+
+func TestUpowerDuration(t *testing.T) {
+	tests := []struct {
+		seconds int64
+		want    string
+	}{
+		{0, "0 seconds"},
+		{59, "59 seconds"},
+		{90, "1.5 minutes"},
+		{11520, "3.2 hours"},
+	}
+	for _, tt := range tests {
+		if got := upowerDuration(tt.seconds); got != tt.want {
+			t.Errorf("upowerDuration(%d) = %q, want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// schemaVersion is sent with every snapshot so the server can tell payload
+// layouts apart. Version 1 was the flat map of preformatted strings.
+const schemaVersion = 2
+
+// displayer is implemented by collector values that also have the
+// preformatted strings the old agent sent, keyed by their old JSON keys.
+type displayer interface {
+	display() map[string]string
+}
+
+// legacyDisplayKeys maps collectors with plain string values to the key the
+// old agent used for them, where it differs from the collector name.
+var legacyDisplayKeys = map[string]string{}
+
+// buildSnapshot assembles the payload for one snapshot from the latest
+// collector results and the events raised since the last snapshot. The
+// typed fields go under "fields". With withDisplay set the snapshot also
+// carries the old display strings at top level, under the keys version 1
+// used, so frontends reading row.hostname or row.total_memory keep working.
+func buildSnapshot(fields map[string]Field, events []Event, withDisplay bool) SystemInfo {
+	info := make(SystemInfo, len(fields)+4)
+	if withDisplay {
+		for name, field := range fields {
+			if field.Status != statusOK {
+				continue
+			}
+			switch v := field.Value.(type) {
+			case displayer:
+				for key, s := range v.display() {
+					info[key] = s
+				}
+			case string:
+				key := name
+				if legacy, ok := legacyDisplayKeys[name]; ok {
+					key = legacy
+				}
+				info[key] = v
+			}
+		}
+	}
+	info["schema_version"] = schemaVersion
+	info["timestamp"] = time.Now().Format(time.RFC3339)
+	info["events"] = events
+	info["fields"] = fields
+	return info
+}
+
+// encodeSnapshot marshals sysInfo wrapped under the agent identity, which the
+// server uses as the table name. A field that cannot be encoded is reported
+// as an error instead, so the rest of the snapshot is still sent.
+func encodeSnapshot(agentID string, sysInfo SystemInfo) []byte {
+	data, err := json.Marshal(map[string]SystemInfo{agentID: sysInfo})
+	if err == nil {
+		return data
+	}
+	fields, _ := sysInfo["fields"].(map[string]Field)
+	for name, field := range fields {
+		if _, err := json.Marshal(field); err != nil {
+			log.Printf("Error marshalling %s to JSON: %v", name, err)
+			fields[name] = Field{
+				Status:      statusError,
+				Message:     "cannot encode value: " + err.Error(),
+				CollectedAt: time.Now().Format(time.RFC3339),
+			}
+		}
+	}
+	if _, err := json.Marshal(sysInfo["events"]); err != nil {
+		log.Println("Error marshalling events to JSON, dropping them:", err)
+		sysInfo["events"] = []Event{}
+	}
+	data, _ = json.Marshal(map[string]SystemInfo{agentID: sysInfo})
+	return data
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCollector("base", &portCollector{}, 30*time.Second)
+}
+
+// udpUnconnected is the state of a bound UDP socket without a peer, the UDP
+// equivalent of a listening TCP socket.
+const udpUnconnected = 0x07
+
+// Linux's default ip_local_port_range, for hosts where it cannot be read.
+const (
+	defaultEphemeralLow  = 32768
+	defaultEphemeralHigh = 60999
+)
+
+// ListeningPorts is every TCP and UDP socket on the host accepting traffic.
+// Unconnected UDP sockets on ports from the ephemeral range are usually
+// clients' and are marked Ephemeral.
+type ListeningPorts struct {
+	Ports []ListeningPort `json:"ports"`
+}
+
+// ListeningPort is one listening socket. Process and PID are known when the
+// agent may read the owning process; User comes from the socket itself.
+type ListeningPort struct {
+	Port     uint16 `json:"port"`
+	Protocol string `json:"protocol"` // tcp or udp
+	Address  string `json:"address"`
+	State    string `json:"state"`
+	Service  string `json:"service"`
+	Process  string `json:"process,omitempty"`
+	PID      int    `json:"pid,omitempty"`
+	User     string `json:"user"`
+	// Ephemeral marks an unconnected UDP socket on a port from the
+	// ephemeral range, most likely a client's.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+}
+
+// display renders the TCP ports the way the nmap self-scan did, which is
+// what the dashboard shows under nmap_scan.
+func (l ListeningPorts) display() map[string]string {
+	var nmapResults []string
+	seen := make(map[uint16]bool)
+	for _, p := range l.Ports {
+		if p.Protocol != "tcp" || seen[p.Port] {
+			continue
+		}
+		seen[p.Port] = true
+		nmapResults = append(nmapResults, fmt.Sprintf("Port: %d, State: %s, Service: %s", p.Port, p.State, p.Service))
+	}
+	if len(nmapResults) == 0 {
+		return map[string]string{"nmap_scan": "No open ports detected"}
+	}
+	return map[string]string{"nmap_scan": strings.Join(nmapResults, "; ")}
+}
+
+func getListeningPorts(ctx context.Context) (ListeningPorts, error) {
+	low, high := ephemeralPorts()
+	var listening []socket
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		sockets, err := readSockets(proto)
+		if err != nil {
+			if strings.HasSuffix(proto, "6") && os.IsNotExist(err) {
+				continue // IPv6 disabled
+			}
+			return ListeningPorts{}, err
+		}
+		for _, s := range sockets {
+			tcp := !strings.HasPrefix(proto, "udp")
+			if tcp && s.State == tcpListen {
+				listening = append(listening, s)
+			}
+			if !tcp && s.State == udpUnconnected && s.Remote.Port() == 0 {
+				listening = append(listening, s)
+			}
+		}
+	}
+
+	owners, _ := socketOwners()
+	services := readServices()
+	users := readUserNames()
+
+	result := ListeningPorts{Ports: []ListeningPort{}}
+	for _, s := range listening {
+		protocol := strings.TrimSuffix(s.Proto, "6")
+		p := ListeningPort{
+			Port:     s.Local.Port(),
+			Protocol: protocol,
+			Address:  s.Local.Addr().String(),
+			State:    "open",
+			Service:  services[strconv.Itoa(int(s.Local.Port()))+"/"+protocol],
+			User:     users[s.UID],
+		}
+		// Clients that use sendto without connect (NTP, mDNS and DHCP
+		// clients, browsers) hold unconnected UDP sockets too, on ports
+		// the kernel picked from the ephemeral range; servers bind below
+		// it, though nothing stops one from binding inside.
+		if protocol == "udp" && p.Port >= low && p.Port <= high {
+			p.Ephemeral = true
+		}
+		if p.Service == "" {
+			p.Service = "unknown" // as nmap reports it
+		}
+		if p.User == "" {
+			p.User = strconv.Itoa(s.UID)
+		}
+		if pids := owners[s.Inode]; len(pids) > 0 {
+			p.PID = pids[0]
+			p.Process = processComm(p.PID)
+		}
+		result.Ports = append(result.Ports, p)
+	}
+	sortPorts(result.Ports)
+	return result, nil
+}
+
+// ephemeralPorts returns the range the kernel picks local ports from for
+// sockets that do not bind one.
+func ephemeralPorts() (low, high uint16) {
+	data, err := readHostFile("/proc/sys/net/ipv4/ip_local_port_range")
+	if err != nil {
+		return defaultEphemeralLow, defaultEphemeralHigh
+	}
+	fields := strings.Fields(data)
+	if len(fields) != 2 {
+		return defaultEphemeralLow, defaultEphemeralHigh
+	}
+	l, err1 := strconv.ParseUint(fields[0], 10, 16)
+	h, err2 := strconv.ParseUint(fields[1], 10, 16)
+	if err1 != nil || err2 != nil || l > h {
+		return defaultEphemeralLow, defaultEphemeralHigh
+	}
+	return uint16(l), uint16(h)
+}
+
+// portCollector reports the listening ports and raises port_opened and
+// port_closed events when the set changes. The last set seen is saved in the
+// state dir, so restarting the agent or rebooting does not report every
+// port as new. Ephemeral ports come and go with the clients holding them and
+// raise no events.
+type portCollector struct {
+	loaded   bool
+	baseline map[string]ListeningPort
+	missing  map[string]bool // in the baseline but absent from the last run
+}
+
+const portStateFile = "ports.json"
+
+func (c *portCollector) Name() string {
+	return "listening_ports"
+}
+
+func (c *portCollector) Collect(ctx context.Context) (interface{}, error) {
+	ports, err := getListeningPorts(ctx)
+	if err != nil {
+		return ListeningPorts{}, err
+	}
+
+	if !c.loaded {
+		var saved []ListeningPort
+		if err := loadState(portStateFile, &saved); err != nil {
+			log.Println("Ignoring unreadable port baseline:", err)
+			saved = nil
+		}
+		c.missing = make(map[string]bool)
+		c.loaded = true
+		if saved == nil {
+			// First start: whatever listens now is the baseline.
+			c.baseline = portSet(ports.Ports)
+			c.save()
+			return ports, nil
+		}
+		c.baseline = portSet(saved)
+	}
+
+	now := time.Now()
+	current := portSet(ports.Ports)
+	for key, p := range current {
+		delete(c.missing, key)
+		if _, ok := c.baseline[key]; !ok {
+			events.emit(c.Name(), "port_opened", now, p)
+		}
+		c.baseline[key] = p
+	}
+	for key, p := range c.baseline {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		// A port has to be gone for two runs in a row before it counts as
+		// closed, so a service restarting, or still starting after a reboot,
+		// does not raise a pair of events.
+		if !c.missing[key] {
+			c.missing[key] = true
+			continue
+		}
+		events.emit(c.Name(), "port_closed", now, p)
+		delete(c.baseline, key)
+		delete(c.missing, key)
+	}
+	c.save()
+	return ports, nil
+}
+
+func (c *portCollector) save() {
+	saved := make([]ListeningPort, 0, len(c.baseline))
+	for _, p := range c.baseline {
+		saved = append(saved, p)
+	}
+	sortPorts(saved)
+	saveCollectorState(portStateFile, saved)
+}
+
+// portSet keys ports by protocol, address and port number, leaving out
+// ephemeral ones.
+func portSet(ports []ListeningPort) map[string]ListeningPort {
+	set := make(map[string]ListeningPort, len(ports))
+	for _, p := range ports {
+		if p.Ephemeral {
+			continue
+		}
+		set[fmt.Sprintf("%s %s %d", p.Protocol, p.Address, p.Port)] = p
+	}
+	return set
+}
+
+func sortPorts(ports []ListeningPort) {
+	sort.Slice(ports, func(i, j int) bool {
+		a, b := ports[i], ports[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Address < b.Address
+	})
+}
+
+// readServices maps "port/protocol" to its name in /etc/services. A host
+// without the file just reports every service as unknown.
+func readServices() map[string]string {
+	services := make(map[string]string)
+	f, err := os.Open(hostPath("/etc/services"))
+	if err != nil {
+		return services
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if _, ok := services[fields[1]]; !ok {
+			services[fields[1]] = fields[0]
+		}
+	}
+	return services
+}
+
+// readUserNames maps UIDs to user names from /etc/passwd.
+func readUserNames() map[int]string {
+	users := make(map[int]string)
+	f, err := os.Open(hostPath("/etc/passwd"))
+	if err != nil {
+		return users
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if uid, err := strconv.Atoi(fields[2]); err == nil {
+			if _, ok := users[uid]; !ok {
+				users[uid] = fields[0]
+			}
+		}
+	}
+	return users
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetListeningPorts(t *testing.T) {
+	useFixtures(t, "laptop")
+	ports, err := getListeningPorts(context.Background())
+	for _, p := range ports.Ports {
+		// the unconnected UDP socket of a client, on an ephemeral port
+		if (p.Port == 50593) != p.Ephemeral {
+			t.Errorf("ephemeral = %v: %+v", p.Ephemeral, p)
+		}
+	}
+	checkGolden(t, "listening_ports", newCollected(ports, err))
+}
+
+func TestEphemeralPorts(t *testing.T) {
+	useFixtures(t, "laptop")
+	if low, high := ephemeralPorts(); low != 32768 || high != 60999 {
+		t.Errorf("ephemeralPorts() = %d, %d from the fixture", low, high)
+	}
+
+	hostRoot = t.TempDir()
+	if low, high := ephemeralPorts(); low != defaultEphemeralLow || high != defaultEphemeralHigh {
+		t.Errorf("ephemeralPorts() = %d, %d without ip_local_port_range", low, high)
+	}
+
+	path := filepath.Join(hostRoot, "proc", "sys", "net", "ipv4", "ip_local_port_range")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("1024\t65535\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if low, high := ephemeralPorts(); low != 1024 || high != 65535 {
+		t.Errorf("ephemeralPorts() = %d, %d, want 1024, 65535", low, high)
+	}
+}
+
+func TestPortCollectorUnwritableState(t *testing.T) {
+	useFixtures(t, "laptop")
+	// a file where the state dir should be, so saving always fails
+	blocked := filepath.Join(t.TempDir(), "state")
+	if err := os.WriteFile(blocked, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := collectorStateDir
+	collectorStateDir = blocked
+	t.Cleanup(func() { collectorStateDir = old })
+
+	c := &portCollector{}
+	for run := 1; run <= 2; run++ {
+		value, err := c.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if ports := value.(ListeningPorts).Ports; len(ports) == 0 {
+			t.Errorf("run %d: no ports", run)
+		}
+	}
+}
+
+// TestPortCollectorEvents changes the socket table between runs and checks
+// the events raised, including the second run a port has to be gone for
+// before it counts as closed.
+func TestPortCollectorEvents(t *testing.T) {
+	useFixtures(t, "")
+	hostRoot = t.TempDir()
+	old := collectorStateDir
+	collectorStateDir = t.TempDir()
+	t.Cleanup(func() { collectorStateDir = old })
+	events.drain()
+
+	const header = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	ssh := "   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 18231 1 0000000000000000 100 0 0 10 0\n"
+	cups := "   1: 0100007F:0277 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 20120 1 0000000000000000 100 0 0 10 0\n"
+	web := "   2: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000    33        0 42733 1 0000000000000000 100 0 0 10 0\n"
+	dir := filepath.Join(hostRoot, "proc", "net")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "udp"), []byte(header), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &portCollector{}
+	run := func(step, tcp string, want ...string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "tcp"), []byte(header+tcp), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Collect(context.Background()); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		got := []string{}
+		for _, e := range events.drain() {
+			got = append(got, fmt.Sprintf("%s %d", e.Type, e.Data.(ListeningPort).Port))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: events %v, want %v", step, got, want)
+		}
+	}
+
+	run("first start", ssh+cups)
+	run("web server started, cups stopped", ssh+web, "port_opened 8080")
+	run("cups still stopped", ssh+web, "port_closed 631")
+	run("unchanged", ssh+web)
+	run("cups back for a run", ssh+cups+web, "port_opened 631")
+	run("cups restarting", ssh+web)
+	run("cups back", ssh+cups+web)
+
+	// a restarted agent compares with the saved baseline
+	c = &portCollector{}
+	run("restart", ssh+web)
+	run("restart, cups gone", ssh+web, "port_closed 631")
+}
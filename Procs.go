@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// socketOwners maps socket inodes to the PIDs holding them open. With the
+// helper configured it asks the helper, which can see every user's
+// processes; otherwise, or if the helper fails, it reads what it can itself.
+// Callers treat the map as best effort: without root it only holds the
+// agent's own processes, and other sockets are reported without an owner.
+func socketOwners() (map[uint64][]int, error) {
+	if helper != nil {
+		owners, err := helper.socketOwners()
+		if err == nil {
+			return owners, nil
+		}
+		log.Println("Error asking the helper for socket owners:", err)
+	}
+	return readSocketOwners()
+}
+
+// readSocketOwners maps socket inodes to PIDs by reading the fd links of
+// every process. Processes the agent may not inspect (other users' without
+// root) are skipped, so the map can be incomplete.
+func readSocketOwners() (map[uint64][]int, error) {
+	entries, err := os.ReadDir(hostPath("/proc"))
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[uint64][]int)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(hostPath("/proc"), e.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if pids := owners[inode]; len(pids) == 0 || pids[len(pids)-1] != pid {
+				owners[inode] = append(pids, pid)
+			}
+		}
+	}
+	for _, pids := range owners {
+		sort.Ints(pids)
+	}
+	return owners, nil
+}
+
+// processCmdline returns the command line of pid as one string. Processes
+// that rewrite their title (sshd: alice@pts/0) show up as they present
+// themselves.
+func processCmdline(pid int) string {
+	data, err := os.ReadFile(hostPath("/proc/" + strconv.Itoa(pid) + "/cmdline"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes.ReplaceAll(data, []byte{0}, []byte{' '})))
+}
+
+// processComm returns the executable name of pid.
+func processComm(pid int) string {
+	comm, _ := readHostFile("/proc/" + strconv.Itoa(pid) + "/comm")
+	return comm
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SSHInfo lists the established SSH sessions of this host, in both
+// directions. ConnectedIPs keeps the old field: the remote addresses of
+// inbound sessions from other hosts.
+type SSHInfo struct {
+	ConnectedIPs []string     `json:"connected_ips"`
+	Sessions     []SSHSession `json:"sessions"`
+}
+
+// SSHSession is one established SSH connection. Inbound sessions are clients
+// connected to this host's sshd; outbound ones are ssh clients running here.
+// PID and User are only known when the agent may read the owning process.
+type SSHSession struct {
+	Direction  string `json:"direction"`
+	LocalIP    string `json:"local_ip"`
+	LocalPort  uint16 `json:"local_port"`
+	RemoteIP   string `json:"remote_ip"`
+	RemotePort uint16 `json:"remote_port"`
+	PID        int    `json:"pid,omitempty"`
+	User       string `json:"user,omitempty"`
+}
+
+func (s SSHInfo) display() map[string]string {
+	if len(s.ConnectedIPs) > 0 {
+		return map[string]string{"ssh_info": fmt.Sprintf("Active SSH connections detected. Connected IPs: %s", strings.Join(s.ConnectedIPs, ", "))}
+	}
+	return map[string]string{"ssh_info": "No active SSH connections"}
+}
+
+const defaultSSHPort = 22
+
+func getSSHInfo(ctx context.Context) (SSHInfo, error) {
+	ports, err := sshdPorts()
+	if err != nil {
+		return SSHInfo{}, err
+	}
+
+	var established []socket
+	for _, proto := range []string{"tcp", "tcp6"} {
+		sockets, err := readSockets(proto)
+		if err != nil {
+			if proto == "tcp6" && os.IsNotExist(err) {
+				continue // IPv6 disabled
+			}
+			return SSHInfo{}, err
+		}
+		for _, s := range sockets {
+			if s.State == tcpEstablished {
+				established = append(established, s)
+			}
+		}
+	}
+
+	owners, _ := socketOwners()
+
+	info := SSHInfo{ConnectedIPs: []string{}, Sessions: []SSHSession{}}
+	for _, s := range established {
+		var pids []int
+		if owners != nil {
+			pids = owners[s.Inode]
+		}
+
+		session := SSHSession{
+			LocalIP:    s.Local.Addr().String(),
+			LocalPort:  s.Local.Port(),
+			RemoteIP:   s.Remote.Addr().String(),
+			RemotePort: s.Remote.Port(),
+		}
+		switch {
+		case ports[s.Local.Port()]:
+			session.Direction = "inbound"
+			session.PID, session.User = sshdSession(pids)
+			if !s.Remote.Addr().IsLoopback() {
+				info.ConnectedIPs = append(info.ConnectedIPs, session.RemoteIP)
+			}
+		case sshClient(pids, s.Remote):
+			session.Direction = "outbound"
+			if len(pids) > 0 {
+				session.PID = pids[0]
+			}
+		default:
+			continue
+		}
+		info.Sessions = append(info.Sessions, session)
+	}
+	return info, nil
+}
+
+// sshClient reports whether a connection belongs to an ssh client: one
+// owned by an ssh, scp or sftp process or, when the owner is unknown, one
+// to the standard SSH port.
+func sshClient(pids []int, remote netip.AddrPort) bool {
+	for _, pid := range pids {
+		switch processComm(pid) {
+		case "ssh", "scp", "sftp":
+			return true
+		}
+	}
+	return len(pids) == 0 && remote.Port() == defaultSSHPort
+}
+
+// sshdSession finds the sshd process serving an inbound connection and the
+// user it is for, from the title sshd gives its per-connection processes:
+// "sshd: alice [priv]", "sshd: alice@pts/0" or, since OpenSSH 9.8,
+// "sshd-session: alice [priv]". Before authentication it is "[net]" or
+// "[accepted]" and no user is known.
+func sshdSession(pids []int) (pid int, user string) {
+	for _, p := range pids {
+		title := processCmdline(p)
+		rest, ok := strings.CutPrefix(title, "sshd: ")
+		if !ok {
+			if rest, ok = strings.CutPrefix(title, "sshd-session: "); !ok {
+				continue
+			}
+		}
+		if pid == 0 {
+			pid = p
+		}
+		name, _, _ := strings.Cut(rest, " ")
+		name, _, _ = strings.Cut(name, "@")
+		if _, err := netip.ParseAddr(name); err == nil {
+			continue // pre-auth titles may carry the client address instead
+		}
+		if name != "" && !strings.HasPrefix(name, "[") && name != "unknown" {
+			return p, name
+		}
+	}
+	if pid == 0 && len(pids) > 0 {
+		pid = pids[0]
+	}
+	return pid, ""
+}
+
+// sshdPorts returns the ports sshd listens on according to its config,
+// following Include directives. Without a config sshd uses port 22.
+func sshdPorts() (map[uint16]bool, error) {
+	ports := make(map[uint16]bool)
+	if err := readSSHDConfig(hostPath("/etc/ssh/sshd_config"), ports, 0); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(ports) == 0 {
+		ports[defaultSSHPort] = true
+	}
+	return ports, nil
+}
+
+// readSSHDConfig adds the ports of Port and ListenAddress directives in path
+// to ports. depth guards against Include loops.
+func readSSHDConfig(path string, ports map[uint16]bool, depth int) error {
+	if depth > 8 {
+		return fmt.Errorf("%s: Include nested too deeply", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// Keywords are case-insensitive and may be separated from their
+		// arguments by "=".
+		i := strings.IndexAny(line, " \t=")
+		if i < 0 {
+			continue
+		}
+		keyword, args := strings.ToLower(line[:i]), strings.Fields(strings.TrimLeft(line[i:], " \t="))
+		if len(args) == 0 {
+			continue
+		}
+		value := args[0]
+		switch keyword {
+		case "match":
+			// Port and ListenAddress are not allowed in Match blocks
+			return scanner.Err()
+		case "port":
+			if port, err := strconv.ParseUint(value, 10, 16); err == nil {
+				ports[uint16(port)] = true
+			}
+		case "listenaddress":
+			if ap, err := netip.ParseAddrPort(value); err == nil {
+				ports[ap.Port()] = true
+			} else if _, port, ok := strings.Cut(value, ":"); ok && !strings.Contains(port, ":") {
+				if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+					ports[uint16(p)] = true
+				}
+			}
+		case "include":
+			for _, pattern := range args {
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join("/etc/ssh", pattern)
+				}
+				matches, err := filepath.Glob(hostPath(pattern))
+				if err != nil {
+					return err
+				}
+				sort.Strings(matches)
+				for _, m := range matches {
+					if err := readSSHDConfig(m, ports, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return scanner.Err()
+}
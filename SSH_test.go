@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+)
+
+func TestGetSSHInfo(t *testing.T) {
+	useFixtures(t, "laptop")
+	checkGolden(t, "ssh", newCollected(getSSHInfo(context.Background())))
+}
+
+func TestSSHDPorts(t *testing.T) {
+	useFixtures(t, "laptop")
+	ports, err := sshdPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 22 from sshd_config, 2222 from the Include'd bastion.conf
+	if len(ports) != 2 || !ports[22] || !ports[2222] {
+		t.Errorf("sshdPorts() = %v, want 22 and 2222", ports)
+	}
+}
+
+func TestSSHDPortsWithoutConfig(t *testing.T) {
+	useFixtures(t, "laptop")
+	hostRoot = t.TempDir()
+	ports, err := sshdPorts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 1 || !ports[defaultSSHPort] {
+		t.Errorf("sshdPorts() = %v, want the default port only", ports)
+	}
+}
+
+func TestSSHClient(t *testing.T) {
+	useFixtures(t, "laptop")
+	remote22 := netip.MustParseAddrPort("93.184.216.34:22")
+	remote443 := netip.MustParseAddrPort("93.184.216.34:443")
+	tests := []struct {
+		pids   []int
+		remote netip.AddrPort
+		want   bool
+	}{
+		{nil, remote22, true}, // owner unknown, standard port
+		{nil, remote443, false},
+		{[]int{2001}, remote443, true}, // owned by ssh
+		{[]int{2210}, remote22, false}, // owned by java
+	}
+	for _, tt := range tests {
+		if got := sshClient(tt.pids, tt.remote); got != tt.want {
+			t.Errorf("sshClient(%v, %s) = %v, want %v", tt.pids, tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestSSHDSession(t *testing.T) {
+	useFixtures(t, "laptop")
+	tests := []struct {
+		pids     []int
+		wantPID  int
+		wantUser string
+	}{
+		{[]int{1184, 1190}, 1184, "alice"}, // "sshd: alice [priv]", "sshd: alice@pts/0"
+		{[]int{1502}, 1502, "bob"},         // "sshd-session: bob [priv]"
+		{[]int{2210}, 2210, ""},            // not sshd
+		{nil, 0, ""},
+	}
+	for _, tt := range tests {
+		pid, user := sshdSession(tt.pids)
+		if pid != tt.wantPID || user != tt.wantUser {
+			t.Errorf("sshdSession(%v) = %d, %q, want %d, %q", tt.pids, pid, user, tt.wantPID, tt.wantUser)
+		}
+	}
+}
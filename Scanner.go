@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// scanMessageType marks scan results, which are sent as messages of their
+// own rather than inside snapshots.
+const scanMessageType = "scan_result"
+
+// ScanResult is the outcome of scanning one configured target with nmap.
+type ScanResult struct {
+	MessageType   string        `json:"message_type"`
+	SchemaVersion int           `json:"schema_version"`
+	Target        string        `json:"target"`
+	Status        string        `json:"status"`
+	Message       string        `json:"message,omitempty"`
+	StartedAt     string        `json:"started_at"`
+	FinishedAt    string        `json:"finished_at"`
+	Hosts         []ScannedHost `json:"hosts"`
+}
+
+type ScannedHost struct {
+	Address   string        `json:"address"`
+	MAC       string        `json:"mac,omitempty"`
+	Vendor    string        `json:"vendor,omitempty"`
+	Hostnames []string      `json:"hostnames"`
+	State     string        `json:"state"`
+	Ports     []ScannedPort `json:"ports"`
+	OS        []OSGuess     `json:"os"`
+}
+
+type ScannedPort struct {
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	State     string `json:"state"`
+	Service   string `json:"service,omitempty"`
+	Product   string `json:"product,omitempty"`
+	Version   string `json:"version,omitempty"`
+	ExtraInfo string `json:"extra_info,omitempty"`
+}
+
+// OSGuess is one of nmap's OS matches, best first.
+type OSGuess struct {
+	Name     string `json:"name"`
+	Accuracy int    `json:"accuracy"`
+}
+
+// nmapRun is the part of nmap's -oX output the scanner reads.
+type nmapRun struct {
+	Hosts []struct {
+		Status struct {
+			State string `xml:"state,attr"`
+		} `xml:"status"`
+		Addresses []struct {
+			Addr     string `xml:"addr,attr"`
+			AddrType string `xml:"addrtype,attr"`
+			Vendor   string `xml:"vendor,attr"`
+		} `xml:"address"`
+		Hostnames []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"hostnames>hostname"`
+		Ports []struct {
+			Protocol string `xml:"protocol,attr"`
+			PortID   int    `xml:"portid,attr"`
+			State    struct {
+				State string `xml:"state,attr"`
+			} `xml:"state"`
+			Service struct {
+				Name      string `xml:"name,attr"`
+				Product   string `xml:"product,attr"`
+				Version   string `xml:"version,attr"`
+				ExtraInfo string `xml:"extrainfo,attr"`
+			} `xml:"service"`
+		} `xml:"ports>port"`
+		OSMatches []struct {
+			Name     string `xml:"name,attr"`
+			Accuracy int    `xml:"accuracy,attr"`
+		} `xml:"os>osmatch"`
+	} `xml:"host"`
+	RunStats struct {
+		Finished struct {
+			Exit   string `xml:"exit,attr"`
+			ErrMsg string `xml:"errormsg,attr"`
+		} `xml:"finished"`
+	} `xml:"runstats"`
+}
+
+// parseNmapXML converts nmap -oX output into scanned hosts.
+func parseNmapXML(data []byte) ([]ScannedHost, error) {
+	var run nmapRun
+	if err := xml.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("parsing nmap XML: %w", err)
+	}
+	if run.RunStats.Finished.Exit == "error" {
+		return nil, fmt.Errorf("nmap: %s", run.RunStats.Finished.ErrMsg)
+	}
+
+	hosts := []ScannedHost{}
+	for _, h := range run.Hosts {
+		host := ScannedHost{
+			State:     h.Status.State,
+			Hostnames: []string{},
+			Ports:     []ScannedPort{},
+			OS:        []OSGuess{},
+		}
+		for _, a := range h.Addresses {
+			switch a.AddrType {
+			case "ipv4", "ipv6":
+				host.Address = a.Addr
+			case "mac":
+				host.MAC, host.Vendor = a.Addr, a.Vendor
+			}
+		}
+		for _, n := range h.Hostnames {
+			host.Hostnames = append(host.Hostnames, n.Name)
+		}
+		for _, p := range h.Ports {
+			host.Ports = append(host.Ports, ScannedPort{
+				Port:      p.PortID,
+				Protocol:  p.Protocol,
+				State:     p.State.State,
+				Service:   p.Service.Name,
+				Product:   p.Service.Product,
+				Version:   p.Service.Version,
+				ExtraInfo: p.Service.ExtraInfo,
+			})
+		}
+		for _, m := range h.OSMatches {
+			host.OS = append(host.OS, OSGuess{Name: m.Name, Accuracy: m.Accuracy})
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// netScanner runs nmap against the configured targets every interval, at
+// most concurrency at a time, and hands each target's result to out.
+type netScanner struct {
+	cfg ScannerConfig
+}
+
+const scannerStateFile = "scanner.json"
+
+type scannerState struct {
+	LastRun time.Time `json:"last_run"`
+}
+
+// Run scans until ctx is done. The time of the last round is saved in the
+// state dir, so restarting the agent does not trigger an early rescan.
+func (s *netScanner) Run(ctx context.Context, agentID string, out chan<- []byte) {
+	var state scannerState
+	if err := loadState(scannerStateFile, &state); err != nil {
+		log.Println("Ignoring unreadable scanner state:", err)
+	}
+	wait := time.Until(state.LastRun.Add(s.cfg.Interval))
+	for {
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		state.LastRun = time.Now()
+		saveCollectorState(scannerStateFile, state)
+		s.round(ctx, agentID, out)
+		if ctx.Err() != nil {
+			return
+		}
+		wait = time.Until(state.LastRun.Add(s.cfg.Interval))
+	}
+}
+
+// round scans every target once.
+func (s *netScanner) round(ctx context.Context, agentID string, out chan<- []byte) {
+	sem := make(chan struct{}, s.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for _, target := range s.cfg.Targets {
+		wg.Add(1)
+		go func(target string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			result := s.scan(ctx, target)
+			<-sem
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case out <- encodeScanResult(agentID, result):
+			case <-ctx.Done():
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+// scanCommand is the nmap command line that scans target. The helper only
+// runs scans that match it word for word.
+func scanCommand(cfg ScannerConfig, target string) []string {
+	argv := append([]string{"nmap"}, cfg.Arguments...)
+	if cfg.Ports != "" {
+		argv = append(argv, "-p", cfg.Ports)
+	}
+	return append(argv, "-oX", "-", target)
+}
+
+// scan runs nmap against one target.
+func (s *netScanner) scan(ctx context.Context, target string) ScanResult {
+	result := ScanResult{
+		MessageType:   scanMessageType,
+		SchemaVersion: schemaVersion,
+		Target:        target,
+		StartedAt:     time.Now().Format(time.RFC3339),
+		Hosts:         []ScannedHost{},
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	argv := scanCommand(s.cfg, target)
+	out, err := runner.Output(ctx, argv[0], argv[1:]...)
+	if err == nil {
+		result.Hosts, err = parseNmapXML(out)
+	}
+	result.FinishedAt = time.Now().Format(time.RFC3339)
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Status = statusTimeout
+		result.Message = "scan took longer than " + s.cfg.Timeout.String()
+	case err != nil:
+		err = commandError("nmap", err)
+		result.Status = statusOf(err)
+		result.Message = err.Error()
+	default:
+		result.Status = statusOK
+	}
+	if result.Status != statusOK {
+		log.Printf("Error scanning %s: %s", target, result.Message)
+	}
+	return result
+}
+
+// scanKey is the top-level key scan results are sent under: the agent
+// identity with a _scan suffix, shortened to stay a valid table name.
+func scanKey(agentID string) string {
+	const suffix = "_scan"
+	if max := maxIdentityLen - len(suffix); len(agentID) > max {
+		agentID = agentID[:max]
+	}
+	return agentID + suffix
+}
+
+func encodeScanResult(agentID string, result ScanResult) []byte {
+	// Only strings and numbers, which always encode
+	data, _ := json.Marshal(map[string]ScanResult{scanKey(agentID): result})
+	return data
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNmapXML(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "commands", "laptop", "nmap_-sV_-O_-oX_-_192.168.1.0_24.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := parseNmapXML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "nmap_hosts", hosts)
+}
+
+func TestParseNmapXMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		xml  string
+		want string
+	}{
+		{"empty stdout", "", "parsing nmap XML"},
+		{"truncated", `<nmaprun><host>`, "parsing nmap XML"},
+		{"nmap error", `<nmaprun><runstats><finished exit="error" errormsg="Failed to resolve target"/></runstats></nmaprun>`, "nmap: Failed to resolve target"},
+	}
+	for _, tt := range tests {
+		_, err := parseNmapXML([]byte(tt.xml))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	cfg := ScannerConfig{Arguments: []string{"-sV", "-O"}, Timeout: time.Minute}
+	tests := []struct {
+		scenario string
+		status   string
+		hosts    int
+	}{
+		{"laptop", statusOK, 2},
+		{"", statusUnavailable, 0}, // nmap not installed
+	}
+	for _, tt := range tests {
+		useFixtures(t, tt.scenario)
+		result := (&netScanner{cfg: cfg}).scan(context.Background(), "192.168.1.0/24")
+		if result.Status != tt.status || len(result.Hosts) != tt.hosts {
+			t.Errorf("scenario %q: status %s (%s), %d hosts, want %s, %d hosts",
+				tt.scenario, result.Status, result.Message, len(result.Hosts), tt.status, tt.hosts)
+		}
+	}
+}
+
+func TestScanCommand(t *testing.T) {
+	cfg := ScannerConfig{Arguments: []string{"-sV", "-O"}}
+	if got := strings.Join(scanCommand(cfg, "192.168.1.0/24"), " "); got != "nmap -sV -O -oX - 192.168.1.0/24" {
+		t.Errorf("scanCommand = %q", got)
+	}
+	cfg.Ports = "22,80"
+	if got := strings.Join(scanCommand(cfg, "10.0.0.1"), " "); got != "nmap -sV -O -p 22,80 -oX - 10.0.0.1" {
+		t.Errorf("scanCommand = %q", got)
+	}
+}
+
+func TestScanKey(t *testing.T) {
+	if got := scanKey("web01_0123456789ab"); got != "web01_0123456789ab_scan" {
+		t.Errorf("scanKey = %q", got)
+	}
+	long := strings.Repeat("a", maxIdentityLen)
+	if got := scanKey(long); len(got) != maxIdentityLen || !validIdentity(got) {
+		t.Errorf("scanKey(%d chars) = %q, not a valid identity", len(long), got)
+	}
+}
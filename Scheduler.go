@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Field is a collector's latest result as reported in a snapshot. Value is
+// only set when Status is ok; otherwise Message says what went wrong.
+type Field struct {
+	Value       interface{} `json:"value,omitempty"`
+	Status      string      `json:"status"`
+	Message     string      `json:"message,omitempty"`
+	CollectedAt string      `json:"collected_at"`
+}
+
+// runOnceRetry is how often a runOnce collector whose startup run failed is
+// tried again, until it succeeds once. It is a variable so the tests can
+// lower it.
+var runOnceRetry = time.Minute
+
+// job is one collector run queued for the worker pool.
+type job struct {
+	c    registration
+	done func()
+}
+
+// scheduler runs every collector on its own interval and caches the latest
+// result of each, so a snapshot never waits on a slow collector. Runs are
+// spread over a bounded pool of workers and each is cut off at its timeout.
+type scheduler struct {
+	collectors []registration
+	workers    int
+	jobs       chan job
+
+	failures *failureLog
+
+	mu      sync.Mutex
+	results map[string]Field
+	running map[string]bool
+}
+
+func newScheduler(collectors []registration, workers int) *scheduler {
+	return &scheduler{
+		collectors: collectors,
+		workers:    workers,
+		jobs:       make(chan job),
+		failures:   newFailureLog("getting"),
+		results:    make(map[string]Field),
+		running:    make(map[string]bool),
+	}
+}
+
+// Start runs every collector once, so the first snapshot is complete, then
+// keeps re-running each one on its interval until ctx is done. A runOnce
+// collector is only re-run if it failed, until it first succeeds.
+func (s *scheduler) Start(ctx context.Context) {
+	for i := 0; i < s.workers; i++ {
+		go s.worker(ctx)
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range s.collectors {
+		wg.Add(1)
+		if !s.submit(ctx, c, wg.Done) {
+			wg.Done()
+		}
+	}
+	wg.Wait()
+
+	for _, c := range s.collectors {
+		if c.interval == runOnce {
+			go s.retry(ctx, c)
+			continue
+		}
+		go s.loop(ctx, c)
+	}
+}
+
+// retry re-runs c every runOnceRetry until it has succeeded once.
+func (s *scheduler) retry(ctx context.Context, c registration) {
+	ticker := time.NewTicker(runOnceRetry)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		ok := s.results[c.Name()].Status == statusOK
+		s.mu.Unlock()
+		if ok {
+			return
+		}
+		s.submit(ctx, c, func() {})
+	}
+}
+
+func (s *scheduler) loop(ctx context.Context, c registration) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.submit(ctx, c, func() {})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// submit queues a run of c unless one is already queued or running, and
+// reports whether it did. done is called once the run has finished.
+func (s *scheduler) submit(ctx context.Context, c registration, done func()) bool {
+	s.mu.Lock()
+	if s.running[c.Name()] {
+		s.mu.Unlock()
+		return false
+	}
+	s.running[c.Name()] = true
+	s.mu.Unlock()
+
+	select {
+	case s.jobs <- job{c: c, done: done}:
+		return true
+	case <-ctx.Done():
+		s.mu.Lock()
+		delete(s.running, c.Name())
+		s.mu.Unlock()
+		return false
+	}
+}
+
+func (s *scheduler) worker(ctx context.Context) {
+	for {
+		select {
+		case j := <-s.jobs:
+			field, returned := s.collect(ctx, j.c)
+			s.mu.Lock()
+			s.results[j.c.Name()] = field
+			s.mu.Unlock()
+			// An abandoned run stays marked running until Collect returns,
+			// so the stateful collectors never run twice at once.
+			go func(name string) {
+				<-returned
+				s.mu.Lock()
+				delete(s.running, name)
+				s.mu.Unlock()
+			}(j.c.Name())
+			j.done()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type collectResult struct {
+	value interface{}
+	err   error
+}
+
+// collect runs c under its timeout. A collector that ignores its context is
+// abandoned when the timeout passes, so it cannot hold a worker forever;
+// returned is closed once its Collect has actually returned.
+func (s *scheduler) collect(ctx context.Context, c registration) (field Field, returned <-chan struct{}) {
+	cctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	ch := make(chan collectResult, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		value, err := c.Collect(cctx)
+		ch <- collectResult{value, err}
+	}()
+
+	var res collectResult
+	select {
+	case res = <-ch:
+	case <-cctx.Done():
+		res.err = cctx.Err()
+	}
+
+	field = Field{CollectedAt: time.Now().Format(time.RFC3339)}
+	switch {
+	case res.err == nil:
+		field.Value, field.Status = res.value, statusOK
+	case cctx.Err() == context.DeadlineExceeded:
+		field.Status, field.Message = statusTimeout, fmt.Sprintf("timed out after %s", c.timeout)
+	default:
+		field.Status, field.Message = statusOf(res.err), res.err.Error()
+	}
+	s.failures.record(c.Name(), field)
+	return field, done
+}
+
+// Snapshot returns the latest cached result of every collector.
+func (s *scheduler) Snapshot() map[string]Field {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields := make(map[string]Field, len(s.results))
+	for name, field := range s.results {
+		fields[name] = field
+	}
+	return fields
+}
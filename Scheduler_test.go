@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testCollector(name string, timeout time.Duration, fn func(ctx context.Context) (string, error)) registration {
+	return registration{Collector: typedCollector[string]{name: name, fn: fn}, interval: time.Hour, timeout: timeout}
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestSchedulerTimeout runs a collector that ignores its context next to a
+// well-behaved one on a single worker.
+func TestSchedulerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	stuck := testCollector("stuck", 20*time.Millisecond, func(ctx context.Context) (string, error) {
+		calls.Add(1)
+		<-release
+		return "late", nil
+	})
+	quick := testCollector("quick", time.Second, func(ctx context.Context) (string, error) {
+		return "fine", nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newScheduler([]registration{stuck, quick}, 1)
+	s.Start(ctx)
+
+	fields := s.Snapshot()
+	if f := fields["stuck"]; f.Status != statusTimeout || f.Message != "timed out after 20ms" || f.Value != nil {
+		t.Errorf("stuck = %+v", f)
+	}
+	if f := fields["quick"]; f.Status != statusOK || f.Value != "fine" {
+		t.Errorf("quick = %+v, want it run by the worker the stuck one let go of", f)
+	}
+
+	// abandoned but still running: no second run may start
+	if s.submit(ctx, stuck, func() {}) {
+		t.Error("stuck collector submitted while its Collect had not returned")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d calls before the first returned", n)
+	}
+
+	close(release)
+	waitFor(t, "the stuck run to be unmarked", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return !s.running["stuck"]
+	})
+	finished := make(chan struct{})
+	if !s.submit(ctx, stuck, func() { close(finished) }) {
+		t.Fatal("stuck collector not submitted after its Collect returned")
+	}
+	<-finished
+	if f := s.Snapshot()["stuck"]; f.Status != statusOK || f.Value != "late" || calls.Load() != 2 {
+		t.Errorf("second run: %+v after %d calls", f, calls.Load())
+	}
+}
+
+// TestSchedulerRunOnceRetry checks that a runOnce collector failing at
+// startup is tried again until it succeeds, and then no more.
+func TestSchedulerRunOnceRetry(t *testing.T) {
+	old := runOnceRetry
+	runOnceRetry = 5 * time.Millisecond
+	t.Cleanup(func() { runOnceRetry = old })
+
+	var calls atomic.Int32
+	c := testCollector("cpu_model", time.Second, func(ctx context.Context) (string, error) {
+		if calls.Add(1) < 3 {
+			return "", errors.New("not yet")
+		}
+		return "Test CPU", nil
+	})
+	c.interval = runOnce
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newScheduler([]registration{c}, 1)
+	s.Start(ctx)
+	if f := s.Snapshot()["cpu_model"]; f.Status != statusError {
+		t.Errorf("startup run = %+v", f)
+	}
+	waitFor(t, "a successful retry", func() bool {
+		return s.Snapshot()["cpu_model"].Status == statusOK
+	})
+	time.Sleep(10 * runOnceRetry)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d runs, want none after the first success", n)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// payloadSchemaJSON is the published JSON Schema for agent messages. Any
+// change to what the agent sends must be reflected there; see schema/README.md.
+//
+//go:embed schema/payload.schema.json
+var payloadSchemaJSON []byte
+
+const payloadSchemaURL = "https://shm-agent/schema/payload.schema.json"
+
+var (
+	payloadSchemaOnce sync.Once
+	payloadSchema     *jsonschema.Schema
+	payloadSchemaErr  error
+)
+
+// validatePayload checks one marshalled message against the payload schema.
+func validatePayload(data []byte) error {
+	payloadSchemaOnce.Do(func() {
+		c := jsonschema.NewCompiler()
+		c.Draft = jsonschema.Draft2020
+		c.AssertFormat = true
+		if err := c.AddResource(payloadSchemaURL, bytes.NewReader(payloadSchemaJSON)); err != nil {
+			payloadSchemaErr = err
+			return
+		}
+		payloadSchema, payloadSchemaErr = c.Compile(payloadSchemaURL)
+	})
+	if payloadSchemaErr != nil {
+		return fmt.Errorf("payload schema: %w", payloadSchemaErr)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	return payloadSchema.Validate(v)
+}
+
+// runCheckSchema implements the check-schema command. With -collect it runs
+// every enabled collector once and validates the resulting snapshot; the
+// tests in Schema_test.go do the same against the fixtures. If the
+// scanner is enabled, one round of scans is validated as well. With
+// -replay and -host-root pointing at fixtures it runs against recorded
+// output instead of this machine. Otherwise it validates messages read one
+// per line from the files given, or stdin.
+func runCheckSchema(args []string) int {
+	fs := flag.NewFlagSet("check-schema", flag.ExitOnError)
+	collect := fs.Bool("collect", false, "collect one snapshot and validate it")
+	replay := fs.String("replay", "", "with -collect, answer commands from the fixtures in this directory instead of running them")
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		return 1
+	}
+
+	if *collect {
+		collectors, err := configuredCollectors(cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		setHostRoot(cfg.HostRoot)
+		if *replay != "" {
+			runner = replayRunner{dir: *replay}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		sched := newScheduler(collectors, cfg.Workers)
+		sched.Start(ctx)
+		cancel()
+
+		data := encodeSnapshot("check_schema", buildSnapshot(sched.Snapshot(), events.drain(), cfg.Display))
+		if err := validatePayload(data); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n%v\n", data, err)
+			return 1
+		}
+		fmt.Println("snapshot matches the payload schema")
+
+		if !cfg.Scanner.Enabled {
+			return 0
+		}
+		scans := make(chan []byte)
+		go func() {
+			scanner := &netScanner{cfg: cfg.Scanner}
+			scanner.round(context.Background(), "check_schema", scans)
+			close(scans)
+		}()
+		failed := false
+		for data := range scans {
+			if err := validatePayload(data); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n%v\n", data, err)
+				failed = true
+			}
+		}
+		if failed {
+			return 1
+		}
+		fmt.Println("scan results match the payload schema")
+		return 0
+	}
+
+	inputs := []io.Reader{os.Stdin}
+	names := []string{"stdin"}
+	if fs.NArg() > 0 {
+		inputs, names = nil, nil
+		for _, name := range fs.Args() {
+			f, err := os.Open(name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			defer f.Close()
+			inputs, names = append(inputs, f), append(names, name)
+		}
+	}
+
+	failed := false
+	for i, r := range inputs {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			if err := validatePayload(scanner.Bytes()); err != nil {
+				fmt.Fprintf(os.Stderr, "%s:%d: %v\n", names[i], line, err)
+				failed = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", names[i], err)
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+// collectSnapshot runs every collector of the base and debian sets once
+// against the fixtures of scenario and encodes the snapshot, as the agent
+// would send it.
+func collectSnapshot(t *testing.T, scenario string) []byte {
+	t.Helper()
+	useFixtures(t, scenario)
+	cfg := defaultConfig()
+	cfg.CollectorSets = []string{"base", "debian"}
+	collectors, err := configuredCollectors(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sched := newScheduler(collectors, cfg.Workers)
+	sched.Start(ctx)
+	return encodeSnapshot("check_schema", buildSnapshot(sched.Snapshot(), events.drain(), true))
+}
+
+func TestSnapshotsMatchSchema(t *testing.T) {
+	tests := []struct {
+		scenario string
+		statuses map[string]string // fields whose status the scenario decides
+	}{
+		{"laptop", map[string]string{"wifi": statusOK, "battery": statusOK, "firewall": statusOK, "ssh_info": statusOK}},
+		{"minimal", map[string]string{"wifi": statusUnavailable, "firewall": statusPermissionDenied}},
+		{"server", map[string]string{"wifi": statusUnavailable, "firewall": statusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scenario, func(t *testing.T) {
+			data := collectSnapshot(t, tt.scenario)
+			if err := validatePayload(data); err != nil {
+				t.Fatalf("%s\n%v", data, err)
+			}
+			var msg map[string]struct {
+				Fields map[string]Field `json:"fields"`
+			}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.statuses {
+				field := msg["check_schema"].Fields[name]
+				if field.Status != want {
+					t.Errorf("%s: status %s (%s), want %s", name, field.Status, field.Message, want)
+				}
+			}
+		})
+	}
+}
+
+// TestLegacyDisplayKeys checks that a frontend written for schema version 1
+// still finds its strings at top level.
+func TestLegacyDisplayKeys(t *testing.T) {
+	var msg map[string]map[string]interface{}
+	if err := json.Unmarshal(collectSnapshot(t, "laptop"), &msg); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"hostname", "total_memory", "used_memory", "uptime", "wifi", "battery", "ssh_info", "OperatingSystem", "DiskUsage"} {
+		if _, ok := msg["check_schema"][key].(string); !ok {
+			t.Errorf("%s = %#v, want a string", key, msg["check_schema"][key])
+		}
+	}
+	if _, ok := msg["check_schema"]["fields"].(map[string]interface{})["hostname"].(map[string]interface{}); !ok {
+		t.Error("no hostname field under fields")
+	}
+
+	sysInfo := buildSnapshot(map[string]Field{"hostname": {Status: statusOK, Value: "web01"}}, nil, false)
+	if _, ok := sysInfo["hostname"]; ok {
+		t.Error("display strings sent with display off")
+	}
+}
+
+func TestScanResultsMatchSchema(t *testing.T) {
+	cfg := ScannerConfig{Arguments: []string{"-sV", "-O"}, Timeout: defaultConfig().Scanner.Timeout}
+	tests := []struct {
+		scenario string
+		status   string
+	}{
+		{"laptop", statusOK},
+		{"", statusUnavailable}, // nmap not installed
+	}
+	for _, tt := range tests {
+		useFixtures(t, tt.scenario)
+		result := (&netScanner{cfg: cfg}).scan(context.Background(), "192.168.1.0/24")
+		if result.Status != tt.status {
+			t.Errorf("scenario %q: status %s (%s), want %s", tt.scenario, result.Status, result.Message, tt.status)
+		}
+		data := encodeScanResult("check_schema", result)
+		if err := validatePayload(data); err != nil {
+			t.Errorf("%s\n%v", data, err)
+		}
+	}
+}
+
+func TestSchemaRejectsBadPayloads(t *testing.T) {
+	valid := collectSnapshot(t, "laptop")
+	tests := []struct {
+		name   string
+		change func(msg map[string]map[string]interface{})
+	}{
+		{"misspelled status", func(msg map[string]map[string]interface{}) {
+			msg["check_schema"]["fields"].(map[string]interface{})["hostname"].(map[string]interface{})["status"] = "okay"
+		}},
+		{"typed value at top level", func(msg map[string]map[string]interface{}) {
+			msg["check_schema"]["total_memory"] = map[string]interface{}{"status": "ok", "value": 1}
+		}},
+		{"wrong schema_version", func(msg map[string]map[string]interface{}) {
+			msg["check_schema"]["schema_version"] = 3
+		}},
+		{"no timestamp", func(msg map[string]map[string]interface{}) {
+			delete(msg["check_schema"], "timestamp")
+		}},
+		{"key not a table name", func(msg map[string]map[string]interface{}) {
+			msg["check schema"] = msg["check_schema"]
+			delete(msg, "check_schema")
+		}},
+	}
+	for _, tt := range tests {
+		var msg map[string]map[string]interface{}
+		if err := json.Unmarshal(valid, &msg); err != nil {
+			t.Fatal(err)
+		}
+		tt.change(msg)
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validatePayload(data); err == nil {
+			t.Errorf("%s: payload accepted", tt.name)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TCP states as numbered in /proc/net/tcp (include/net/tcp_states.h).
+const (
+	tcpEstablished = 0x01
+	tcpListen      = 0x0A
+)
+
+// socket is one line of /proc/net/{tcp,tcp6,udp,udp6}.
+type socket struct {
+	Proto  string // tcp, tcp6, udp or udp6
+	Local  netip.AddrPort
+	Remote netip.AddrPort
+	State  int
+	UID    int
+	Inode  uint64
+}
+
+// readSockets parses the kernel socket table for proto. IPv4-mapped IPv6
+// addresses are unmapped, so callers see a single address family for IPv4.
+func readSockets(proto string) ([]socket, error) {
+	f, err := os.Open(hostPath("/proc/net/" + proto))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sockets []socket
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		local, err1 := parseProcAddr(fields[1])
+		remote, err2 := parseProcAddr(fields[2])
+		state, err3 := strconv.ParseInt(fields[3], 16, 0)
+		uid, err4 := strconv.Atoi(fields[7])
+		inode, err5 := strconv.ParseUint(fields[9], 10, 64)
+		for _, err := range []error{err1, err2, err3, err4, err5} {
+			if err != nil {
+				return nil, fmt.Errorf("%s: malformed line %q: %w", f.Name(), scanner.Text(), err)
+			}
+		}
+		sockets = append(sockets, socket{
+			Proto:  proto,
+			Local:  local,
+			Remote: remote,
+			State:  int(state),
+			UID:    uid,
+			Inode:  inode,
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseProcAddr parses an "ADDR:PORT" pair from /proc/net/tcp. The address
+// is printed as 32-bit words in host byte order, the port in hex.
+func parseProcAddr(s string) (netip.AddrPort, error) {
+	addrHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("no port in %q", s)
+	}
+	raw, err := hex.DecodeString(addrHex)
+	if err != nil || (len(raw) != 4 && len(raw) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("bad address %q", addrHex)
+	}
+	for i := 0; i < len(raw); i += 4 {
+		binary.NativeEndian.PutUint32(raw[i:], binary.BigEndian.Uint32(raw[i:]))
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("bad port %q", portHex)
+	}
+	addr, _ := netip.AddrFromSlice(raw)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseProcAddr(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "0100007F:0016", want: "127.0.0.1:22"},
+		{in: "00000000:0000", want: "0.0.0.0:0"},
+		{in: "0F02000A:8A3C", want: "10.0.2.15:35388"},
+		{in: "00000000000000000000000000000000:0016", want: "[::]:22"},
+		{in: "B80D01200000000000000000A2010000:0016", want: "[2001:db8::1a2]:22"},
+		{in: "00000000000000000000000001000000:0277", want: "[::1]:631"},
+		// IPv4-mapped addresses in tcp6 come out as plain IPv4
+		{in: "0000000000000000FFFF00000F02000A:0016", want: "10.0.2.15:22"},
+		{in: "0100007F", wantErr: true},
+		{in: "0100007:0016", wantErr: true},
+		{in: "0100007F:XYZ", wantErr: true},
+		{in: "0100007F00:0016", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseProcAddr(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseProcAddr(%q) = %s, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("parseProcAddr(%q) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestReadSockets(t *testing.T) {
+	for _, proto := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		t.Run(proto, func(t *testing.T) {
+			useFixtures(t, "laptop")
+			sockets, err := readSockets(proto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			type row struct {
+				Local, Remote string
+				State, UID    int
+				Inode         uint64
+			}
+			rows := []row{}
+			for _, s := range sockets {
+				rows = append(rows, row{s.Local.String(), s.Remote.String(), s.State, s.UID, s.Inode})
+			}
+			checkGolden(t, "sockets_"+proto, rows)
+		})
+	}
+}
+
+func TestReadSocketOwners(t *testing.T) {
+	useFixtures(t, "laptop")
+	owners, err := readSocketOwners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "socket_owners", owners)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentSuffix = ".seg"
+	cursorFile    = "cursor"
+	frameHeader   = 8 // record length + CRC32, both big endian uint32
+
+	// cursorEvery is how many replayed records go between cursor saves, and
+	// so at most how many are sent again after a crash during replay.
+	cursorEvery = 64
+
+	// maxRecordBytes is the largest record the spool takes. It bounds the
+	// length replay trusts in a frame header independently of the segment
+	// size, which may have been lowered since the record was written.
+	maxRecordBytes = 64 << 20
+)
+
+var errCorruptRecord = errors.New("corrupt spool record")
+
+// spoolOptions caps how much undelivered data the spool keeps. Once a cap is
+// exceeded the oldest segments are dropped.
+type spoolOptions struct {
+	MaxSegmentBytes int64
+	MaxBytes        int64
+	MaxAge          time.Duration
+}
+
+var defaultSpoolOptions = spoolOptions{
+	MaxSegmentBytes: 4 << 20,
+	MaxBytes:        256 << 20,
+	MaxAge:          7 * 24 * time.Hour,
+}
+
+// spool is a disk-backed FIFO of marshalled snapshots. Records are appended to
+// numbered segment files; a cursor file remembers how far replay has got, so
+// nothing is lost across restarts and, should the agent die mid-replay, no
+// more than cursorEvery records are sent twice.
+type spool struct {
+	dir  string
+	opts spoolOptions
+
+	mu       sync.Mutex
+	segments []uint64 // sequence numbers on disk, oldest first; the last is being written
+	w        *os.File
+	wSize    int64
+
+	readSeq    uint64
+	readOffset int64
+}
+
+// openSpool opens (or creates) the spool in dir. Appends always go to a
+// fresh segment so a record torn by a crash is never written after.
+func openSpool(dir string, opts spoolOptions) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	s := &spool{dir: dir, opts: opts}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, seq)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	s.readSeq, s.readOffset = s.loadCursor()
+
+	var next uint64 = 1
+	if n := len(s.segments); n > 0 {
+		next = s.segments[n-1] + 1
+	}
+	if err := s.openSegment(next); err != nil {
+		return nil, err
+	}
+	known := false
+	for _, seq := range s.segments {
+		known = known || seq == s.readSeq
+	}
+	if !known {
+		s.readSeq, s.readOffset = s.segments[0], 0
+	}
+	return s, nil
+}
+
+// Append durably stores one record. A record larger than the segment size
+// gets a segment of its own.
+func (s *spool) Append(data []byte) error {
+	if len(data) > maxRecordBytes {
+		return fmt.Errorf("record of %d bytes is over the spool's %d byte limit", len(data), maxRecordBytes)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(frameHeader + len(data))
+	if s.wSize > 0 && s.wSize+size > s.opts.MaxSegmentBytes {
+		if err := s.openSegment(s.segments[len(s.segments)-1] + 1); err != nil {
+			return err
+		}
+	}
+
+	frame := make([]byte, size)
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(data))
+	copy(frame[frameHeader:], data)
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+	if err := s.w.Sync(); err != nil {
+		return err
+	}
+	s.wSize += size
+
+	s.enforceCaps()
+	return nil
+}
+
+// Empty reports whether every appended record has been replayed.
+func (s *spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.emptyLocked()
+}
+
+func (s *spool) emptyLocked() bool {
+	return len(s.segments) == 1 && s.readSeq == s.segments[0] && s.readOffset >= s.wSize
+}
+
+// Replay hands every pending record to send, oldest first. It stops at the
+// first send error, keeping that record for the next call.
+func (s *spool) Replay(send func([]byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.emptyLocked() {
+		active := s.readSeq == s.segments[len(s.segments)-1]
+		err := s.replaySegment(send)
+		if err == nil && active {
+			break
+		}
+		if err != nil && !errors.Is(err, errCorruptRecord) {
+			s.saveCursor()
+			return err
+		}
+		if errors.Is(err, errCorruptRecord) {
+			log.Printf("Spool segment %d is damaged, skipping the rest of it: %v", s.readSeq, err)
+			if active {
+				// Never skip past what is still being written.
+				s.readOffset = s.wSize
+				break
+			}
+		}
+		s.removeSegment(s.readSeq)
+		s.readSeq, s.readOffset = s.segments[0], 0
+		s.saveCursor()
+	}
+	s.saveCursor()
+	return nil
+}
+
+// replaySegment sends the records of the current read segment from the read
+// offset to its end.
+func (s *spool) replaySegment(send func([]byte) error) error {
+	f, err := os.Open(s.segmentPath(s.readSeq))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(s.readOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(f)
+	header := make([]byte, frameHeader)
+	for sent := 1; ; sent++ {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", errCorruptRecord, err)
+		}
+		length := binary.BigEndian.Uint32(header[0:4])
+		if length > maxRecordBytes {
+			return fmt.Errorf("%w: length %d", errCorruptRecord, length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("%w: %v", errCorruptRecord, err)
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+			return fmt.Errorf("%w: checksum mismatch", errCorruptRecord)
+		}
+		if err := send(data); err != nil {
+			return err
+		}
+		s.readOffset += int64(frameHeader) + int64(length)
+		if sent%cursorEvery == 0 {
+			s.saveCursor()
+		}
+	}
+}
+
+// Close closes the segment being written.
+func (s *spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saveCursor()
+	return s.w.Close()
+}
+
+func (s *spool) openSegment(seq uint64) error {
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	if s.w != nil {
+		s.w.Close()
+	}
+	s.w, s.wSize = f, 0
+	s.segments = append(s.segments, seq)
+	return nil
+}
+
+// enforceCaps drops the oldest finished segments while the spool is over its
+// size cap or they are older than the age cap.
+func (s *spool) enforceCaps() {
+	var total int64
+	sizes := make(map[uint64]int64, len(s.segments))
+	for _, seq := range s.segments {
+		if fi, err := os.Stat(s.segmentPath(seq)); err == nil {
+			sizes[seq] = fi.Size()
+			total += fi.Size()
+		}
+	}
+
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		tooOld := false
+		if fi, err := os.Stat(s.segmentPath(oldest)); err == nil && s.opts.MaxAge > 0 {
+			tooOld = time.Since(fi.ModTime()) > s.opts.MaxAge
+		}
+		if !tooOld && (s.opts.MaxBytes <= 0 || total <= s.opts.MaxBytes) {
+			return
+		}
+		log.Printf("Spool over its limits, dropping segment %d (%d bytes)", oldest, sizes[oldest])
+		total -= sizes[oldest]
+		s.removeSegment(oldest)
+		if s.readSeq == oldest {
+			s.readSeq, s.readOffset = s.segments[0], 0
+		}
+	}
+}
+
+func (s *spool) removeSegment(seq uint64) {
+	if err := os.Remove(s.segmentPath(seq)); err != nil && !os.IsNotExist(err) {
+		log.Println("Error removing spool segment:", err)
+	}
+	for i, v := range s.segments {
+		if v == seq {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+func (s *spool) loadCursor() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if err != nil {
+		return 0, 0
+	}
+	var seq uint64
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seq, &offset); err != nil {
+		return 0, 0
+	}
+	return seq, offset
+}
+
+func (s *spool) saveCursor() {
+	path := filepath.Join(s.dir, cursorFile)
+	tmp := path + ".tmp"
+	data := []byte(fmt.Sprintf("%d %d\n", s.readSeq, s.readOffset))
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		log.Println("Error saving spool cursor:", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Println("Error saving spool cursor:", err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestSpool(t *testing.T, dir string, opts spoolOptions) *spool {
+	t.Helper()
+	s, err := openSpool(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// record is the n-th test record, 42 bytes long, a 50 byte frame.
+func record(n int) []byte {
+	return []byte(fmt.Sprintf("record %03d %s", n, strings.Repeat("x", 31)))
+}
+
+func appendRecords(t *testing.T, s *spool, from, to int) {
+	t.Helper()
+	for n := from; n < to; n++ {
+		if err := s.Append(record(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// replayAll replays everything and returns the record numbers sent.
+func replayAll(t *testing.T, s *spool) []int {
+	t.Helper()
+	got := []int{}
+	err := s.Replay(func(data []byte) error {
+		var n int
+		if _, err := fmt.Sscanf(string(data), "record %d", &n); err != nil {
+			t.Fatalf("replayed %q", data)
+		}
+		got = append(got, n)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Empty() {
+		t.Error("spool not empty after replay")
+	}
+	return got
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func sequence(from, to int) []int {
+	s := []int{}
+	for n := from; n < to; n++ {
+		s = append(s, n)
+	}
+	return s
+}
+
+func TestSpoolRollover(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, spoolOptions{MaxSegmentBytes: 200, MaxBytes: 1 << 20})
+	if !s.Empty() {
+		t.Error("new spool not empty")
+	}
+	appendRecords(t, s, 0, 10) // 4 records per segment
+	if n := len(segmentFiles(t, dir)); n != 3 {
+		t.Errorf("%d segments, want 3", n)
+	}
+	if got := replayAll(t, s); fmt.Sprint(got) != fmt.Sprint(sequence(0, 10)) {
+		t.Errorf("replayed %v", got)
+	}
+	if n := len(segmentFiles(t, dir)); n != 1 {
+		t.Errorf("%d segments left after replay, want the one being written", n)
+	}
+
+	appendRecords(t, s, 10, 12)
+	if got := replayAll(t, s); fmt.Sprint(got) != fmt.Sprint(sequence(10, 12)) {
+		t.Errorf("replayed %v after more appends", got)
+	}
+}
+
+func TestSpoolSizeCap(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, spoolOptions{MaxSegmentBytes: 200, MaxBytes: 600})
+	appendRecords(t, s, 0, 20)
+	// 1000 bytes in 5 segments: the oldest two are dropped
+	if got := replayAll(t, s); fmt.Sprint(got) != fmt.Sprint(sequence(8, 20)) {
+		t.Errorf("replayed %v", got)
+	}
+}
+
+func TestSpoolAgeCap(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, spoolOptions{MaxSegmentBytes: 200, MaxBytes: 1 << 20, MaxAge: time.Hour})
+	appendRecords(t, s, 0, 8)
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(segmentFiles(t, dir)[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, s, 8, 9)
+	if got := replayAll(t, s); fmt.Sprint(got) != fmt.Sprint(sequence(4, 9)) {
+		t.Errorf("replayed %v", got)
+	}
+}
+
+func TestSpoolOversizedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestSpool(t, dir, spoolOptions{MaxSegmentBytes: 1 << 20, MaxBytes: 1 << 20})
+	big := []byte("record 000 " + strings.Repeat("y", 1000))
+	if err := s.Append(big); err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(t, s, 1, 2)
+	s.Close()
+
+	// reopened with a segment size below the record's
+	s = openTestSpool(t, dir, spoolOptions{MaxSegmentBytes: 100, MaxBytes: 1 << 20})
+	if err := s.Append(big); err != nil {
+		t.Fatal(err)
+	}
+	if got := replayAll(t, s); fmt.Sprint(got) != "[0 1 0]" {
+		t.Errorf("replayed %v", got)
+	}
+
+	if err := s.Append(make([]byte, maxRecordBytes+1)); err == nil {
+		t.Error("record over maxRecordBytes appended")
+	}
+}
+
+func TestSpoolCursorAcrossReopen(t *testing.T) {
+	dir := t.TempDir()
+	opts := spoolOptions{MaxSegmentBytes: 200, MaxBytes: 1 << 20}
+	s := openTestSpool(t, dir, opts)
+	appendRecords(t, s, 0, 6)
+
+	errDown := errors.New("connection lost")
+	sent := 0
+	err := s.Replay(func(data []byte) error {
+		if sent == 3 {
+			return errDown
+		}
+		sent++
+		return nil
+	})
+	if !errors.Is(err, errDown) {
+		t.Fatalf("Replay = %v, want the send error", err)
+	}
+	s.Close()
+
+	s = openTestSpool(t, dir, opts)
+	appendRecords(t, s, 6, 7)
+	if got := replayAll(t, s); fmt.Sprint(got) != fmt.Sprint(sequence(3, 7)) {
+		t.Errorf("replayed %v after reopen", got)
+	}
+}
+
+func TestSpoolDamagedSegments(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+	}{
+		{"torn", func(data []byte) []byte { return data[:len(data)-10] }},
+		{"checksum", func(data []byte) []byte {
+			data[2*50+20] ^= 0xff // inside the third record
+			return data
+		}},
+		{"length", func(data []byte) []byte {
+			data[2*50] = 0xff // the third record's length
+			return data
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := spoolOptions{MaxSegmentBytes: 200, MaxBytes: 1 << 20}
+			s := openTestSpool(t, dir, opts)
+			appendRecords(t, s, 0, 4)
+			s.Close()
+
+			path := segmentFiles(t, dir)[0]
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.damage(data), 0o640); err != nil {
+				t.Fatal(err)
+			}
+
+			// the rest of the damaged segment is skipped, later ones are not
+			s = openTestSpool(t, dir, opts)
+			appendRecords(t, s, 4, 6)
+			want := "[0 1 4 5]"
+			if tt.name == "torn" {
+				want = "[0 1 2 4 5]"
+			}
+			if got := replayAll(t, s); fmt.Sprint(got) != want {
+				t.Errorf("replayed %v, want %s", got, want)
+			}
+		})
+	}
+}
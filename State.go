@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// collectorStateDir is where collectors keep what must survive a restart,
+// such as how far they have read a log. It is set from state_dir before the
+// collectors start; when empty (check-schema) state is kept in memory only.
+var collectorStateDir string
+
+// loadState decodes the JSON state file name into v. A missing file, or no
+// state dir, leaves v untouched.
+func loadState(name string, v interface{}) error {
+	if collectorStateDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(collectorStateDir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stateFailures rate-limits logging of state files that cannot be saved.
+var stateFailures = newFailureLog("saving")
+
+// saveCollectorState saves v as the state file name, logging a failure
+// rather than returning it: the collector's value is still good, only what
+// it remembers across a restart is at risk, so it must not turn the field
+// into an error. A failure that persists is logged every failureLogInterval.
+func saveCollectorState(name string, v interface{}) {
+	field := Field{Status: statusOK}
+	if err := saveState(name, v); err != nil {
+		field.Status, field.Message = statusError, err.Error()
+	}
+	stateFailures.record(name, field)
+}
+
+// saveState writes v as the JSON state file name, replacing it atomically.
+func saveState(name string, v interface{}) error {
+	if collectorStateDir == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(collectorStateDir, 0o750); err != nil {
+		return err
+	}
+	path := filepath.Join(collectorStateDir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Statuses reported with every field of a snapshot.
+const (
+	statusOK          = "ok"          // Value holds a fresh result
+	statusUnavailable = "unavailable" // this host lacks the tool, device or file
+	statusError       = "error"       // the collector ran and failed
+	statusTimeout     = "timeout"     // the collector overran its timeout
+
+	statusPermissionDenied = "permission_denied" // the agent lacks the privilege to read it
+)
+
+// errUnavailable marks errors meaning the information does not exist on this
+// host, as opposed to a failure to read it.
+var errUnavailable = errors.New("unavailable")
+
+// unavailableError is an error that matches errUnavailable.
+type unavailableError struct {
+	msg string
+}
+
+func (e unavailableError) Error() string {
+	return e.msg
+}
+
+func (e unavailableError) Is(target error) bool {
+	return target == errUnavailable
+}
+
+// unavailable returns an error reported with status unavailable.
+func unavailable(format string, args ...interface{}) error {
+	return unavailableError{msg: fmt.Sprintf(format, args...)}
+}
+
+// permissionError is an error that matches os.ErrPermission, for tools that
+// refuse to run unprivileged rather than failing a system call.
+type permissionError struct {
+	msg string
+}
+
+func (e permissionError) Error() string {
+	return e.msg
+}
+
+func (e permissionError) Is(target error) bool {
+	return target == os.ErrPermission
+}
+
+// rootRequiredRe matches how command-line tools say they need root.
+var rootRequiredRe = regexp.MustCompile(`(?i)need to be root|must be (run as )?root|requires? root|permission denied|operation not permitted`)
+
+// commandError describes a failed external command. A command that is not
+// installed is unavailable, one that needs more privilege than the agent has
+// is permission denied; otherwise the first line of its stderr is kept.
+func commandError(name string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return unavailable("%s is not installed", name)
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		if line, _, _ := bytes.Cut(bytes.TrimSpace(exitErr.stderr), []byte("\n")); len(line) > 0 {
+			if rootRequiredRe.Match(line) {
+				return permissionError{msg: fmt.Sprintf("%s: %s", name, line)}
+			}
+			return fmt.Errorf("%s: %v: %s", name, err, line)
+		}
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// statusOf classifies a collector error.
+func statusOf(err error) string {
+	switch {
+	case err == nil:
+		return statusOK
+	case errors.Is(err, errUnavailable), errors.Is(err, os.ErrNotExist):
+		return statusUnavailable
+	case errors.Is(err, os.ErrPermission):
+		return statusPermissionDenied
+	}
+	return statusError
+}
+
+// failureLogInterval is how long an unchanged failure stays quiet in the log.
+const failureLogInterval = 10 * time.Minute
+
+// failureLog rate-limits logging of collector failures: a new failure is
+// logged at once, the same failure again at most every failureLogInterval
+// with a count of how often it recurred, and a recovery once.
+type failureLog struct {
+	verb string // what failed, as in "Error getting <name>"
+
+	mu   sync.Mutex
+	last map[string]*failureEntry
+}
+
+type failureEntry struct {
+	msg      string
+	loggedAt time.Time
+	repeats  int
+}
+
+func newFailureLog(verb string) *failureLog {
+	return &failureLog{verb: verb, last: make(map[string]*failureEntry)}
+}
+
+// record notes the outcome of one run of the named collector.
+func (l *failureLog) record(name string, field Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := l.last[name]
+	if field.Status == statusOK {
+		if e != nil {
+			log.Printf("%s recovered", name)
+			delete(l.last, name)
+		}
+		return
+	}
+
+	msg := field.Status + ": " + field.Message
+	now := time.Now()
+	switch {
+	case e == nil || e.msg != msg:
+		log.Printf("Error %s %s: %s", l.verb, name, msg)
+		l.last[name] = &failureEntry{msg: msg, loggedAt: now}
+	case now.Sub(e.loggedAt) >= failureLogInterval:
+		log.Printf("Error %s %s: %s (repeated %d times)", l.verb, name, msg, e.repeats+1)
+		e.loggedAt, e.repeats = now, 0
+	default:
+		e.repeats++
+	}
+}
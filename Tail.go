@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// maxReadPerRun caps how much of a log one collector run reads, so a large
+// backlog is worked off over several runs instead of all at once. It is a
+// variable so the tests can lower it.
+var maxReadPerRun = 4 << 20
+
+// fileCursor remembers how far a growing log file has been read. It is
+// saved in the state dir so nothing is reported twice across restarts.
+type fileCursor struct {
+	Inode   uint64 `json:"inode"`
+	Offset  int64  `json:"offset"`
+	Started bool   `json:"started"` // false until the first read attempt
+}
+
+// readNew returns the complete records appended to path since cur and
+// advances cur past them. complete returns how many leading bytes of its
+// argument form whole records; a trailing partial record is left for the
+// next call.
+//
+// When path has been rotated, i.e. its inode changed, the rest of the old
+// file is read first from path.1, where logrotate moves it, over as many
+// calls as maxReadPerRun needs. A zero cursor starts at the current end of
+// the file, so history from before the agent started is not reported. A
+// file that only appears later is read from its start.
+func readNew(path string, cur *fileCursor, complete func([]byte) int) ([]byte, error) {
+	first := !cur.Started
+	cur.Started = true
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	inode := fileInode(st)
+
+	if first {
+		cur.Inode, cur.Offset = inode, st.Size()
+		return nil, nil
+	}
+
+	var data []byte
+	if inode != cur.Inode {
+		if old, err := os.Open(path + ".1"); err == nil {
+			if ost, err := old.Stat(); err == nil && fileInode(ost) == cur.Inode {
+				buf, n, eof, err := readFrom(old, cur.Offset, complete)
+				if err == nil && !eof {
+					old.Close()
+					cur.Offset += n
+					return buf, nil
+				}
+				data = buf
+			}
+			old.Close()
+		}
+		cur.Inode, cur.Offset = inode, 0
+	} else if st.Size() < cur.Offset {
+		cur.Offset = 0 // truncated in place
+	}
+
+	buf, n, _, err := readFrom(f, cur.Offset, complete)
+	if err != nil {
+		return nil, err
+	}
+	cur.Offset += n
+	return append(data, buf...), nil
+}
+
+// readFrom reads the complete records of f from offset on, at most
+// maxReadPerRun bytes of them. It returns the records, how far to advance
+// the offset and whether the end of f was reached. A record longer than
+// maxReadPerRun could never be completed, so a full read without one is
+// skipped instead of being read again and again.
+func readFrom(f *os.File, offset int64, complete func([]byte) int) ([]byte, int64, bool, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, false, err
+	}
+	buf, err := io.ReadAll(io.LimitReader(f, int64(maxReadPerRun)))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	n := complete(buf)
+	if len(buf) < maxReadPerRun {
+		return buf[:n], int64(n), true, nil
+	}
+	if n == 0 {
+		return nil, int64(len(buf)), false, nil
+	}
+	return buf[:n], int64(n), false, nil
+}
+
+func fileInode(st os.FileInfo) uint64 {
+	return st.Sys().(*syscall.Stat_t).Ino
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLines(t *testing.T, path string, cur *fileCursor) string {
+	t.Helper()
+	data, err := readNew(path, cur, wholeLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// lowerReadLimit makes maxReadPerRun n bytes for the test.
+func lowerReadLimit(t *testing.T, n int) {
+	old := maxReadPerRun
+	maxReadPerRun = n
+	t.Cleanup(func() { maxReadPerRun = old })
+}
+
+func TestReadNewFirstStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "before the agent\n")
+
+	cur := &fileCursor{}
+	if got := readLines(t, path, cur); got != "" {
+		t.Errorf("first read = %q, want nothing from before the start", got)
+	}
+	appendFile(t, path, "one\ntw")
+	if got := readLines(t, path, cur); got != "one\n" {
+		t.Errorf("read %q, want the complete line only", got)
+	}
+	appendFile(t, path, "o\n")
+	if got := readLines(t, path, cur); got != "two\n" {
+		t.Errorf("read %q after the line was completed", got)
+	}
+
+	// a log that only appears after the agent started is read from its start
+	missing := filepath.Join(t.TempDir(), "auth.log")
+	cur = &fileCursor{}
+	if _, err := readNew(missing, cur, wholeLines); !os.IsNotExist(err) {
+		t.Fatalf("readNew of a missing file = %v", err)
+	}
+	appendFile(t, missing, "first\n")
+	if got := readLines(t, missing, cur); got != "first\n" {
+		t.Errorf("read %q from a new file", got)
+	}
+}
+
+func TestReadNewTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "")
+	cur := &fileCursor{}
+	readLines(t, path, cur)
+	appendFile(t, path, "a long line before truncation\n")
+	readLines(t, path, cur)
+
+	if err := os.WriteFile(path, []byte("short\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLines(t, path, cur); got != "short\n" {
+		t.Errorf("read %q after truncation", got)
+	}
+}
+
+func TestReadNewRotated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "")
+	cur := &fileCursor{}
+	readLines(t, path, cur)
+	appendFile(t, path, "old 1\n")
+	readLines(t, path, cur)
+
+	appendFile(t, path, "old 2\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "new 1\n")
+	if got := readLines(t, path, cur); got != "old 2\nnew 1\n" {
+		t.Errorf("read %q after rotation", got)
+	}
+	appendFile(t, path, "new 2\n")
+	if got := readLines(t, path, cur); got != "new 2\n" {
+		t.Errorf("read %q after rotation", got)
+	}
+}
+
+// TestReadNewRotatedBacklog checks that a rotated file with more left in it
+// than one read takes is finished over the following reads.
+func TestReadNewRotatedBacklog(t *testing.T) {
+	lowerReadLimit(t, 16)
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "")
+	cur := &fileCursor{}
+	readLines(t, path, cur)
+
+	appendFile(t, path, "old 1\nold 2\nold 3\nold 4\nold 5\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "new 1\n")
+
+	var got strings.Builder
+	for run := 0; run < 5; run++ {
+		got.WriteString(readLines(t, path, cur))
+	}
+	if want := "old 1\nold 2\nold 3\nold 4\nold 5\nnew 1\n"; got.String() != want {
+		t.Errorf("read %q, want %q", got.String(), want)
+	}
+}
+
+// TestReadNewLongRecord checks that a record longer than a read is skipped
+// rather than holding up the log for good.
+func TestReadNewLongRecord(t *testing.T) {
+	lowerReadLimit(t, 16)
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "")
+	cur := &fileCursor{}
+	readLines(t, path, cur)
+
+	appendFile(t, path, strings.Repeat("x", 40)+"\nafter\n")
+	var got strings.Builder
+	for run := 0; run < 5; run++ {
+		got.WriteString(readLines(t, path, cur))
+	}
+	if !strings.HasSuffix(got.String(), "\nafter\n") {
+		t.Errorf("read %q, want the line after the long one", got.String())
+	}
+}